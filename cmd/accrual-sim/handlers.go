@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type simulator struct {
+	cfg   *config
+	store *store
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowReqs  int
+}
+
+func newSimulator(cfg *config) *simulator {
+	return &simulator{
+		cfg:   cfg,
+		store: newStore(),
+	}
+}
+
+func (s *simulator) GetOrder(w http.ResponseWriter, r *http.Request) {
+	if !s.allowRequest() || rand.Float64() < s.cfg.TooManyRate {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "No more than %d requests per minute allowed", s.cfg.RateLimit)
+		return
+	}
+	if rand.Float64() < s.cfg.ServerErrRate {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	number := mux.Vars(r)["number"]
+	o, err := s.store.GetOrder(number)
+	if errors.Is(err, errNotFound) && s.cfg.AutoRegister {
+		o = s.newOrder(number, math.Round(rand.Float64()*s.cfg.MaxAutoAccrual*100)/100)
+		if addErr := s.store.AddOrder(o); addErr != nil {
+			o, err = s.store.GetOrder(number) // registered concurrently
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, o.Info(s.cfg.RegisteredFor, s.cfg.ProcessingFor))
+}
+
+func (s *simulator) RegisterOrder(w http.ResponseWriter, r *http.Request) {
+	req := &struct {
+		Order string  `json:"order"`
+		Goods []*good `json:"goods"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || !isNumber(req.Order) {
+		http.Error(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	o := s.newOrder(req.Order, s.store.CalcAccrual(req.Goods))
+	if err := s.store.AddOrder(o); err != nil {
+		http.Error(w, "order is already registered", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *simulator) AddReward(w http.ResponseWriter, r *http.Request) {
+	rew := new(reward)
+	err := json.NewDecoder(r.Body).Decode(rew)
+	if err != nil || rew.Match == "" || rew.Reward < 0 ||
+		(rew.RewardType != rewardPercent && rew.RewardType != rewardPoints) {
+		http.Error(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.AddReward(rew); err != nil {
+		http.Error(w, "reward for `"+rew.Match+"` already exists", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *simulator) newOrder(number string, accrual float64) *order {
+	return &order{
+		Number:       number,
+		Accrual:      accrual,
+		Invalid:      rand.Float64() < s.cfg.InvalidRate,
+		RegisteredAt: time.Now(),
+	}
+}
+
+// Fixed one minute window limiter for `-rpm`.
+func (s *simulator) allowRequest() bool {
+	if s.cfg.RateLimit <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.windowStart) >= time.Minute {
+		s.windowStart = time.Now()
+		s.windowReqs = 0
+	}
+	s.windowReqs++
+	return s.windowReqs <= s.cfg.RateLimit
+}
+
+func (s *simulator) withLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay := s.cfg.Latency
+		if s.cfg.LatencyJitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(s.cfg.LatencyJitter)))
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "response failed", http.StatusInternalServerError)
+	}
+}
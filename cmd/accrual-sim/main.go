@@ -0,0 +1,73 @@
+// Accrual system simulator. Implements the accrual API from SPECIFICATION.md
+// so gophermart can be run end to end without the external accrual binary.
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type config struct {
+	RunAddress     string
+	Latency        time.Duration // added to every response
+	LatencyJitter  time.Duration // random extra latency up to this value
+	RateLimit      int           // requests per minute for `GET /api/orders/{number}`, 0 means no limit
+	TooManyRate    float64       // probability of a random `429`
+	ServerErrRate  float64       // probability of a random `500`
+	InvalidRate    float64       // probability of an order to end up INVALID
+	RegisteredFor  time.Duration // how long an order stays REGISTERED
+	ProcessingFor  time.Duration // how long an order stays PROCESSING
+	AutoRegister   bool          // register unknown orders on the first request
+	MaxAutoAccrual float64       // max accrual of an auto registered order
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+func main() {
+	cfg := parseConfig()
+
+	sim := newSimulator(cfg)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/orders/{number}", sim.GetOrder).Methods("GET")
+	r.HandleFunc("/api/orders", sim.RegisterOrder).Methods("POST")
+	r.HandleFunc("/api/goods", sim.AddReward).Methods("POST")
+	r.Use(sim.withLatency)
+
+	server := &http.Server{
+		Addr:              cfg.RunAddress,
+		Handler:           r,
+		ReadHeaderTimeout: 2 * time.Second,
+	}
+	log.Println("Accrual simulator is serving at http://" + cfg.RunAddress + "/")
+	log.Fatalln(server.ListenAndServe())
+}
+
+func parseConfig() *config {
+	cfg := &config{}
+	flag.StringVar(&cfg.RunAddress, "a", "localhost:8888", "Server address.")
+	flag.DurationVar(&cfg.Latency, "latency", 0, "Latency added to every response.")
+	flag.DurationVar(&cfg.LatencyJitter, "latency-jitter", 0, "Max random latency added on top of -latency.")
+	flag.IntVar(&cfg.RateLimit, "rpm", 0, "Max order info requests per minute, 0 means no limit.")
+	flag.Float64Var(&cfg.TooManyRate, "429-rate", 0, "Probability of a random 429 response.")
+	flag.Float64Var(&cfg.ServerErrRate, "500-rate", 0, "Probability of a random 500 response.")
+	flag.Float64Var(&cfg.InvalidRate, "invalid-rate", 0, "Probability of an order to become INVALID.")
+	flag.DurationVar(&cfg.RegisteredFor, "registered-for", time.Second, "How long an order stays REGISTERED.")
+	flag.DurationVar(&cfg.ProcessingFor, "processing-for", time.Second, "How long an order stays PROCESSING.")
+	flag.BoolVar(&cfg.AutoRegister, "autoregister", false, "Register unknown orders with a random accrual.")
+	flag.Float64Var(&cfg.MaxAutoAccrual, "max-auto-accrual", 1000, "Max accrual of an auto registered order.")
+	flag.Parse()
+
+	if addr, ok := os.LookupEnv("RUN_ADDRESS"); ok {
+		cfg.RunAddress = addr
+	}
+	return cfg
+}
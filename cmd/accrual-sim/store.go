@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REGISTERED = "REGISTERED"
+	INVALID    = "INVALID"
+	PROCESSING = "PROCESSING"
+	PROCESSED  = "PROCESSED"
+)
+
+const (
+	rewardPercent = "%"
+	rewardPoints  = "pt"
+)
+
+var (
+	errAlreadyExists = errors.New("already exists")
+	errNotFound      = errors.New("not found")
+)
+
+type reward struct {
+	Match      string  `json:"match"`
+	Reward     float64 `json:"reward"`
+	RewardType string  `json:"reward_type"`
+}
+
+type good struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+type order struct {
+	Number       string
+	Accrual      float64
+	Invalid      bool // decided at registration, revealed after processing
+	RegisteredAt time.Time
+}
+
+type orderInfo struct {
+	Order   string   `json:"order"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
+
+type store struct {
+	mu      sync.RWMutex
+	rewards []*reward
+	orders  map[string]*order
+}
+
+func newStore() *store {
+	return &store{
+		orders: map[string]*order{},
+	}
+}
+
+func (s *store) AddReward(r *reward) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.rewards {
+		if existing.Match == r.Match {
+			return errAlreadyExists
+		}
+	}
+	s.rewards = append(s.rewards, r)
+	return nil
+}
+
+func (s *store) AddOrder(o *order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[o.Number]; ok {
+		return errAlreadyExists
+	}
+	s.orders[o.Number] = o
+	return nil
+}
+
+func (s *store) GetOrder(number string) (*order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.orders[number]
+	if !ok {
+		return nil, errNotFound
+	}
+	return o, nil
+}
+
+// Sums rewards of all goods, the first matching reward rule is applied to each good.
+func (s *store) CalcAccrual(goods []*good) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	total := 0.0
+	for _, g := range goods {
+		for _, r := range s.rewards {
+			if !strings.Contains(g.Description, r.Match) {
+				continue
+			}
+			switch r.RewardType {
+			case rewardPercent:
+				total += g.Price * r.Reward / 100
+			case rewardPoints:
+				total += r.Reward
+			}
+			break
+		}
+	}
+	return math.Round(total*100) / 100
+}
+
+// The status of an order depends on how long ago it was registered:
+// REGISTERED → PROCESSING → PROCESSED/INVALID.
+func (o *order) Info(registeredFor, processingFor time.Duration) *orderInfo {
+	info := &orderInfo{Order: o.Number}
+	elapsed := time.Since(o.RegisteredAt)
+	switch {
+	case elapsed < registeredFor:
+		info.Status = REGISTERED
+	case elapsed < registeredFor+processingFor:
+		info.Status = PROCESSING
+	case o.Invalid:
+		info.Status = INVALID
+	default:
+		info.Status = PROCESSED
+		accrual := o.Accrual
+		info.Accrual = &accrual
+	}
+	return info
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -17,12 +22,27 @@ import (
 
 	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/balance"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/campaign"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/config"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/expiry"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/idempotency"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/middleware"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/monitor"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/order"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/reconcile"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/referral"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/tier"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/user"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/voucher"
+)
+
+const (
+	shutdownTimeout      = 10 * time.Second
+	holdExpiryInterval   = time.Minute
+	pointsExpiryInterval = time.Hour
 )
 
 func init() {
@@ -31,6 +51,11 @@ func init() {
 
 func main() {
 	cfg := config.Parse()
+	appLogger := logger.Run(cfg.LogLevel)
+
+	// Lives as long as the app, background jobs stop when it's done
+	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	db, openDBErr := sql.Open("pgx", cfg.DatabaseURI)
 	if openDBErr != nil {
@@ -45,23 +70,99 @@ func main() {
 	if err := migrateDB(db); err != nil {
 		log.Fatal("can't migrate db", err)
 	}
+	checkLedger(appCtx, db)
 
 	userRepo := user.NewRepo(db)
 	orderRepo := order.NewRepo(db)
 	balanceRepo := balance.NewRepo(db)
 	sessionRepo := session.NewSessionRepo(db)
 
-	accrualClient := accrual.NewHTTPClient(cfg.AccrualSystemAddress,
-		cfg.AccrualPollingLimit, cfg.AccrualRequestTimeout, cfg.AccrualPollingInterval)
+	accrualHTTP := accrual.NewHTTPClient(cfg.AccrualSystemAddress, cfg.AccrualRequestTimeout,
+		accrual.RetryPolicy{
+			BaseDelay:   cfg.AccrualPollingInterval,
+			Multiplier:  cfg.AccrualBackoffFactor,
+			MaxDelay:    cfg.AccrualBackoffMaxDelay,
+			Jitter:      cfg.AccrualBackoffJitter,
+			MaxElapsed:  cfg.AccrualMaxElapsed,
+			MaxAttempts: cfg.AccrualPollingLimit,
+		})
+	accrualClient := accrual.NewCircuitBreaker(accrualHTTP,
+		cfg.AccrualBreakerFailures, cfg.AccrualBreakerCooldown, cfg.AccrualBreakerProbes)
 
 	sessionService := session.NewSessionService(cfg.SecretKey, sessionRepo)
-	orderService := order.NewService(orderRepo, accrualClient)
+	clawbackPolicy, err := order.ParseClawbackPolicy(cfg.ClawbackPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	orderService := order.NewService(orderRepo, accrualClient, cfg.AccrualWorkers, cfg.AccrualQueueSize, clawbackPolicy)
 	userService := user.NewService(userRepo, sessionService)
-	balanceService := balance.NewService(balanceRepo)
+	tiers, err := tier.ParseTiers(cfg.Tiers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tierService := tier.NewService(tier.NewRepo(db), tiers)
+	campaignService := campaign.NewService(campaign.NewRepo(db), tiers)
+	referralService := referral.NewService(referral.NewRepo(db), cfg.ReferralReward)
+	userRepo.AddRegisteredHook(referralService.Refer)
+	// Campaign tier rules use the tier recorded by the tier hook, it goes first
+	orderRepo.AddProcessedHook(tierService.CreditBonus)
+	orderRepo.AddProcessedHook(campaignService.CreditBonuses)
+	orderRepo.AddProcessedHook(referralService.CreditRewards)
+
+	expiryService := expiry.NewService(expiry.NewRepo(db), cfg.PointsExpireMonths, cfg.PointsExpiringSoon)
+	balanceService := balance.NewService(balanceRepo, expiryService, cfg.HoldTTL,
+		balance.TransferLimits{Min: cfg.TransferMin, Daily: cfg.TransferDailyLimit})
 
-	userHandler := user.NewHandler(userService)
+	var bgJobs sync.WaitGroup
+	bgJobs.Add(1)
+	go func() {
+		defer bgJobs.Done()
+		orderService.RunAccrualPolling(appCtx)
+	}()
+
+	bgJobs.Add(1)
+	go func() {
+		defer bgJobs.Done()
+		balanceService.RunHoldExpiry(appCtx, holdExpiryInterval)
+	}()
+
+	bgJobs.Add(1)
+	go func() {
+		defer bgJobs.Done()
+		expiryService.RunScheduled(appCtx, pointsExpiryInterval)
+	}()
+
+	if cfg.ReconcileInterval > 0 {
+		reconciler := reconcile.NewService(reconcile.NewRepo(db), accrualClient, orderRepo)
+		bgJobs.Add(1)
+		go func() {
+			defer bgJobs.Done()
+			reconciler.RunScheduled(appCtx, cfg.ReconcileInterval, cfg.ReconcileWindow, cfg.ReconcileApply)
+		}()
+	}
+
+	userHandler := user.NewHandler(userService, cfg.ReferralIPHeader)
 	orderHandler := order.NewOrderHandler(orderService)
+	webhookHandler := order.NewWebhookHandler(orderService, cfg.AccrualWebhookSecret)
+	cancelHandler := order.NewCancelHandler(orderService, cfg.PartnerSecret)
 	balanceHandler := balance.NewBalanceHandler(balanceService)
+	tierHandler := tier.NewHandler(tierService)
+	campaignHandler := campaign.NewHandler(campaignService)
+	referralHandler := referral.NewHandler(referralService)
+	voucherHandler := voucher.NewHandler(voucher.NewService(voucher.NewRepo(db)))
+	monitorHandler := monitor.NewHandler()
+	monitorHandler.Register("accrual_pool", func() interface{} { return orderService.AccrualStats() })
+	monitorHandler.Register("accrual_breaker", func() interface{} { return accrualClient.Stats() })
+	monitorHandler.Register("stalled_orders", func() interface{} {
+		return orderService.StalledOrdersCount(appCtx)
+	})
+
+	idempotent := middleware.NewIdempotencyMiddleware(idempotency.NewRepo(db), cfg.IdempotencyTTL)
+	bgJobs.Add(1)
+	go func() {
+		defer bgJobs.Done()
+		idempotent.RunCleanup(appCtx, time.Hour)
+	}()
 
 	r := mux.NewRouter()
 	api := r.PathPrefix("/api").Subrouter()
@@ -72,22 +173,69 @@ func main() {
 	api.HandleFunc("/user/logout", userHandler.LogOut).Methods("POST")
 
 	// Order
-	api.HandleFunc("/user/orders", orderHandler.AddOrder).Methods("POST")
+	api.Handle("/user/orders", idempotent.Middleware(http.HandlerFunc(orderHandler.AddOrder))).Methods("POST")
 	api.HandleFunc("/user/orders", orderHandler.GetOrdersList).Methods("GET")
 
+	// Accrual updates pushed by the accrual system
+	api.HandleFunc("/accrual/webhook", webhookHandler.AccrualUpdate).Methods("POST")
+
+	// Cancellations pushed by partners, signed with the partner secret
+	api.HandleFunc("/partner/orders/cancel", cancelHandler.PartnerCancel).Methods("POST")
+
 	// Balance
 	api.HandleFunc("/user/balance", balanceHandler.GetUserBalance).Methods("GET")
-	api.HandleFunc("/user/balance/withdraw", balanceHandler.Withdraw).Methods("POST")
+	api.Handle("/user/balance/withdraw", idempotent.Middleware(http.HandlerFunc(balanceHandler.Withdraw))).Methods("POST")
 	api.HandleFunc("/user/withdrawals", balanceHandler.Withdrawals).Methods("GET")
+	api.HandleFunc("/user/balance/history", balanceHandler.History).Methods("GET")
+	api.Handle("/user/balance/transfers", idempotent.Middleware(http.HandlerFunc(balanceHandler.Transfer))).Methods("POST")
+	api.HandleFunc("/user/balance/transfers", balanceHandler.Transfers).Methods("GET")
+
+	// Loyalty tier
+	api.HandleFunc("/user/tier", tierHandler.GetUserTier).Methods("GET")
+
+	// Referrals
+	api.HandleFunc("/user/referrals", referralHandler.GetUserReferrals).Methods("GET")
+
+	// Vouchers
+	api.Handle("/user/vouchers/redeem", idempotent.Middleware(http.HandlerFunc(voucherHandler.Redeem))).Methods("POST")
+
+	// Holds: points reserved at checkout and withdrawn once the purchase completes
+	api.Handle("/user/balance/holds", idempotent.Middleware(http.HandlerFunc(balanceHandler.CreateHold))).Methods("POST")
+	api.HandleFunc("/user/balance/holds", balanceHandler.Holds).Methods("GET")
+	api.Handle("/user/balance/holds/{id:[0-9]+}/capture",
+		idempotent.Middleware(http.HandlerFunc(balanceHandler.CaptureHold))).Methods("POST")
+	api.Handle("/user/balance/holds/{id:[0-9]+}/release",
+		idempotent.Middleware(http.HandlerFunc(balanceHandler.ReleaseHold))).Methods("POST")
+
+	// Support API, authorized by the admin token instead of user sessions
+	adminAuth := middleware.NewAdminMiddleware(cfg.AdminToken)
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(adminAuth.Middleware)
+	admin.HandleFunc("/withdrawals/{order}/reversals", balanceHandler.ReverseWithdrawal).Methods("POST")
+	admin.HandleFunc("/orders/{order}/cancel", cancelHandler.AdminCancel).Methods("POST")
+	admin.HandleFunc("/campaigns", campaignHandler.Create).Methods("POST")
+	admin.HandleFunc("/campaigns", campaignHandler.Campaigns).Methods("GET")
+	admin.HandleFunc("/campaigns/{id:[0-9]+}", campaignHandler.Campaign).Methods("GET")
+	admin.HandleFunc("/campaigns/{id:[0-9]+}", campaignHandler.Update).Methods("PUT")
+	admin.HandleFunc("/campaigns/{id:[0-9]+}", campaignHandler.Delete).Methods("DELETE")
+	admin.HandleFunc("/vouchers/batches", voucherHandler.CreateBatch).Methods("POST")
+	admin.HandleFunc("/vouchers/batches", voucherHandler.Batches).Methods("GET")
+
+	// Monitoring, authorized by the admin token too
+	r.Handle("/internal/stats", adminAuth.Middleware(http.HandlerFunc(monitorHandler.Stats))).Methods("GET")
 
 	noAuthUrls := map[string]struct{}{
 		"/api/user/login":    {},
 		"/api/user/register": {},
+		"/internal/stats":    {},
+
+		"/api/accrual/webhook":       {},
+		"/api/partner/orders/cancel": {},
 	}
-	auth := middleware.NewAuthMiddleware(sessionService, userRepo, noAuthUrls)
+	auth := middleware.NewAuthMiddleware(sessionService, userRepo, noAuthUrls, "/api/admin/")
 	r.Use(auth.Middleware)
 
-	logMiddleware := middleware.NewLoggingMiddleware(logger.Run(cfg.LogLevel))
+	logMiddleware := middleware.NewLoggingMiddleware(appLogger)
 	r.Use(logMiddleware.SetupTracing)
 	r.Use(logMiddleware.SetupLogging)
 	r.Use(logMiddleware.AccessLog)
@@ -97,8 +245,36 @@ func main() {
 		Handler:           r,
 		ReadHeaderTimeout: 2 * time.Second,
 	}
+	go func() {
+		<-appCtx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("server shutdown failed: %v", err)
+		}
+	}()
+
 	log.Println("Serving at http://" + cfg.RunAddress + "/")
-	log.Fatalln(server.ListenAndServe())
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalln(err)
+	}
+
+	// Let pollers finish their work and unlock their jobs
+	bgJobs.Wait()
+	log.Println("Server stopped")
+}
+
+// Reports users whose cached balance columns don't match the ledger.
+func checkLedger(ctx context.Context, db *sql.DB) {
+	found, err := ledger.NewRepo(db).CheckConsistency(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("ledger consistency check failed: %v", err)
+		return
+	}
+	for _, i := range found {
+		logger.Log(ctx).Warnf("ledger: user `%s` cached balance %s / held %s / withdrawn %s, ledger says %s / %s / %s",
+			i.UserID, i.CachedBalance, i.CachedHeld, i.CachedWithdrawn, i.LedgerBalance, i.LedgerHeld, i.LedgerWithdrawn)
+	}
 }
 
 func migrateDB(db *sql.DB) error {
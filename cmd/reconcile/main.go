@@ -0,0 +1,110 @@
+// Compares orders with the accrual system and reports the drift.
+//
+// Usage: reconcile -d=<db> -r=<accrual address> -from=2022-10-01 -to=2022-11-01 [-apply]
+//
+// With -ledger it checks the cached user balances against the ledger instead.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/campaign"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/config"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/order"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/reconcile"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/referral"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/tier"
+)
+
+const dateLayout = "2006-01-02"
+
+func main() {
+	// Registered before `config.Parse` which parses all flags
+	flagFrom := flag.String("from", time.Now().AddDate(0, 0, -7).Format(dateLayout),
+		"Reconcile orders uploaded since this date (YYYY-MM-DD or RFC3339).")
+	flagTo := flag.String("to", time.Now().AddDate(0, 0, 1).Format(dateLayout),
+		"Reconcile orders uploaded before this date (YYYY-MM-DD or RFC3339).")
+	flagApply := flag.Bool("apply", false, "Correct mismatches and adjust user balances.")
+	flagLedger := flag.Bool("ledger", false, "Check cached user balances against the ledger.")
+
+	cfg := config.Parse()
+	logger.Run(cfg.LogLevel)
+
+	from, err := parseTime(*flagFrom)
+	if err != nil {
+		log.Fatalf("bad -from value: %v", err)
+	}
+	to, err := parseTime(*flagTo)
+	if err != nil {
+		log.Fatalf("bad -to value: %v", err)
+	}
+
+	db, err := sql.Open("pgx", cfg.DatabaseURI)
+	if err != nil {
+		log.Fatalf("unable to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *flagLedger {
+		found, checkErr := ledger.NewRepo(db).CheckConsistency(ctx)
+		if checkErr != nil {
+			log.Fatalf("ledger check failed: %v", checkErr)
+		}
+		printJSON(found)
+		return
+	}
+
+	// Corrected orders get the same bonuses as in the gophermart server
+	tiers, err := tier.ParseTiers(cfg.Tiers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	orderRepo := order.NewRepo(db)
+	orderRepo.AddProcessedHook(tier.NewService(tier.NewRepo(db), tiers).CreditBonus)
+	orderRepo.AddProcessedHook(campaign.NewService(campaign.NewRepo(db), tiers).CreditBonuses)
+	orderRepo.AddProcessedHook(referral.NewService(referral.NewRepo(db), cfg.ReferralReward).CreditRewards)
+
+	accrualClient := accrual.NewHTTPClient(cfg.AccrualSystemAddress, cfg.AccrualRequestTimeout, accrual.RetryPolicy{})
+	reconciler := reconcile.NewService(reconcile.NewRepo(db), accrualClient, orderRepo)
+
+	report, err := reconciler.Reconcile(ctx, from, to, *flagApply)
+	if report == nil {
+		log.Fatalf("reconciliation failed: %v", err)
+	}
+
+	printJSON(report)
+	if err != nil {
+		log.Fatalf("reconciliation interrupted: %v", err)
+	}
+}
+
+func printJSON(data interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		log.Fatalf("can't print report: %v", err)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(dateLayout, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS accrual_jobs(
+  order_id VARCHAR(128) PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+  user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  locked_until TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS accrual_jobs_next_run_at_idx ON accrual_jobs(next_run_at);
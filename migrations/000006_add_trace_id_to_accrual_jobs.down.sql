@@ -0,0 +1 @@
+ALTER TABLE accrual_jobs DROP COLUMN IF EXISTS trace_id;
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- Postgres can't drop a value from an enum, STALLED orders are turned back to NEW.
+UPDATE orders SET status = 'NEW' WHERE status = 'STALLED';
\ No newline at end of file
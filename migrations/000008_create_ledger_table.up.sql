@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS ledger(
+  id BIGSERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  kind VARCHAR(32) NOT NULL,
+  debit_account VARCHAR(64) NOT NULL,
+  credit_account VARCHAR(64) NOT NULL,
+  amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+  order_id VARCHAR(128),
+  withdrawal_id INTEGER REFERENCES withdrawals(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (debit_account <> credit_account)
+);
+CREATE INDEX IF NOT EXISTS ledger_user_id_idx ON ledger(user_id, created_at);
+CREATE INDEX IF NOT EXISTS ledger_debit_account_idx ON ledger(debit_account);
+CREATE INDEX IF NOT EXISTS ledger_credit_account_idx ON ledger(credit_account);
+
+-- History recorded before the ledger existed
+INSERT INTO ledger(user_id, kind, debit_account, credit_account, amount, order_id, created_at)
+SELECT user_id, 'ACCRUAL', 'system:accrual', 'user:' || user_id, accrual, id, uploaded_at
+FROM orders WHERE status = 'PROCESSED' AND accrual > 0 AND user_id IS NOT NULL;
+
+INSERT INTO ledger(user_id, kind, debit_account, credit_account, amount, order_id, withdrawal_id, created_at)
+SELECT user_id, 'WITHDRAWAL', 'user:' || user_id, 'system:withdrawals', sum, order_id, id, processed_at
+FROM withdrawals WHERE sum > 0 AND user_id IS NOT NULL;
+
+-- Opening adjustments for balances the history doesn't explain
+WITH diff AS (
+  SELECT u.id, u.balance - COALESCE((
+    SELECT SUM(CASE WHEN l.credit_account = 'user:' || u.id THEN l.amount ELSE -l.amount END)
+    FROM ledger l WHERE l.debit_account = 'user:' || u.id OR l.credit_account = 'user:' || u.id
+  ), 0) AS amount
+  FROM users u
+)
+INSERT INTO ledger(user_id, kind, debit_account, credit_account, amount)
+SELECT id, 'ADJUSTMENT',
+  CASE WHEN amount > 0 THEN 'system:adjustments' ELSE 'user:' || id END,
+  CASE WHEN amount > 0 THEN 'user:' || id ELSE 'system:adjustments' END,
+  ABS(amount)
+FROM diff WHERE amount <> 0;
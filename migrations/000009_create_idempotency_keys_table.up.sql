@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  key VARCHAR(255) NOT NULL,
+  request_hash BYTEA NOT NULL,
+  status_code INTEGER, -- NULL while the first request is in progress
+  headers JSONB,
+  body BYTEA,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys(expires_at);
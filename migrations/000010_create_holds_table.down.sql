@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS holds;
+ALTER TABLE users DROP COLUMN IF EXISTS held;
\ No newline at end of file
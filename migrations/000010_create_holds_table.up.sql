@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS held NUMERIC(8, 2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS holds(
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  order_id VARCHAR(128) NOT NULL,
+  sum NUMERIC(8, 2) NOT NULL CHECK (sum > 0),
+  status VARCHAR(16) NOT NULL DEFAULT 'ACTIVE',
+  withdrawal_id INTEGER REFERENCES withdrawals(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  closed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS holds_user_id_idx ON holds(user_id, created_at);
+CREATE INDEX IF NOT EXISTS holds_active_expires_at_idx ON holds(expires_at) WHERE status = 'ACTIVE';
+-- An order can't be paid by two holds at once
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_order_id_idx ON holds(order_id) WHERE status = 'ACTIVE';
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS withdrawal_reversals(
+  id SERIAL PRIMARY KEY,
+  withdrawal_id INTEGER NOT NULL REFERENCES withdrawals(id) ON DELETE CASCADE,
+  sum NUMERIC(8, 2) NOT NULL CHECK (sum > 0),
+  reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS withdrawal_reversals_withdrawal_id_idx ON withdrawal_reversals(withdrawal_id);
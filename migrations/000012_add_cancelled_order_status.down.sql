@@ -0,0 +1,3 @@
+-- Postgres can't drop a value from an enum, CANCELLED orders had their accrual
+-- taken back, so they are turned to INVALID.
+UPDATE orders SET status = 'INVALID' WHERE status = 'CANCELLED';
\ No newline at end of file
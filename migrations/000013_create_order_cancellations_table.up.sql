@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS order_cancellations(
+  order_id VARCHAR(128) PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+  user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+  previous_status VARCHAR(16) NOT NULL,
+  source VARCHAR(16) NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  accrual NUMERIC(8, 2) NOT NULL DEFAULT 0,
+  clawed_back NUMERIC(8, 2) NOT NULL DEFAULT 0,
+  unrecovered NUMERIC(8, 2) NOT NULL DEFAULT 0, -- accrual already spent and not taken back
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ledger_lot_usages;
+DROP TABLE IF EXISTS ledger_lots;
\ No newline at end of file
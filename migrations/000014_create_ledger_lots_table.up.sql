@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS ledger_lots(
+  id BIGSERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  posting_id BIGINT NOT NULL REFERENCES ledger(id) ON DELETE CASCADE,
+  amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+  remaining NUMERIC(10, 2) NOT NULL CHECK (remaining >= 0),
+  earned_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS ledger_lots_user_id_idx ON ledger_lots(user_id, earned_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS ledger_lots_earned_at_idx ON ledger_lots(earned_at) WHERE remaining > 0;
+
+-- How much of each lot a posting took (positive) or returned (negative)
+CREATE TABLE IF NOT EXISTS ledger_lot_usages(
+  posting_id BIGINT NOT NULL REFERENCES ledger(id) ON DELETE CASCADE,
+  lot_id BIGINT NOT NULL REFERENCES ledger_lots(id) ON DELETE CASCADE,
+  amount NUMERIC(10, 2) NOT NULL,
+  PRIMARY KEY (posting_id, lot_id)
+);
+CREATE INDEX IF NOT EXISTS ledger_lot_usages_lot_id_idx ON ledger_lot_usages(lot_id);
+
+-- The current balance is made of the latest credits, older ones are considered spent
+INSERT INTO ledger_lots(user_id, posting_id, amount, remaining, earned_at)
+SELECT user_id, id, amount, LEAST(amount, balance - newer), created_at FROM (
+  SELECT l.user_id, l.id, l.amount, l.created_at, u.balance,
+    COALESCE(SUM(l.amount) OVER (PARTITION BY l.user_id ORDER BY l.created_at DESC, l.id DESC
+                                 ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING), 0) AS newer
+  FROM ledger l JOIN users u ON u.id = l.user_id
+  WHERE l.credit_account = 'user:' || l.user_id
+) credits
+WHERE balance - newer > 0;
@@ -0,0 +1,2 @@
+ALTER TABLE ledger DROP COLUMN IF EXISTS transfer_id;
+DROP TABLE IF EXISTS transfers;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS transfers(
+  id SERIAL PRIMARY KEY,
+  from_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  to_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  sum NUMERIC(8, 2) NOT NULL CHECK (sum > 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (from_user_id <> to_user_id)
+);
+CREATE INDEX IF NOT EXISTS transfers_from_user_id_idx ON transfers(from_user_id, created_at);
+CREATE INDEX IF NOT EXISTS transfers_to_user_id_idx ON transfers(to_user_id, created_at);
+
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS transfer_id INTEGER REFERENCES transfers(id) ON DELETE SET NULL;
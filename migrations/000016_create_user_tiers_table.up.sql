@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_tiers(
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  tier VARCHAR(32) NOT NULL,
+  accrued NUMERIC(10, 2) NOT NULL, -- points accrued during the rolling window when the tier changed
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS user_tiers_user_id_idx ON user_tiers(user_id, created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS ledger_campaign_order_idx;
+ALTER TABLE ledger DROP COLUMN IF EXISTS campaign_id;
+DROP TABLE IF EXISTS campaigns;
\ No newline at end of file
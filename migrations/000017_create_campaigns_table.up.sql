@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS campaigns(
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(128) NOT NULL,
+  starts_at TIMESTAMPTZ NOT NULL,
+  ends_at TIMESTAMPTZ NOT NULL,
+  first_order BOOLEAN NOT NULL DEFAULT FALSE,
+  tier VARCHAR(32) NOT NULL DEFAULT '',
+  order_prefix VARCHAR(128) NOT NULL DEFAULT '',
+  multiplier NUMERIC(6, 2) NOT NULL DEFAULT 1 CHECK (multiplier >= 1),
+  fixed NUMERIC(8, 2) NOT NULL DEFAULT 0 CHECK (fixed >= 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (starts_at < ends_at)
+);
+CREATE INDEX IF NOT EXISTS campaigns_window_idx ON campaigns(starts_at, ends_at);
+
+-- Campaign bonuses are credited once per order
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS campaign_id INTEGER REFERENCES campaigns(id);
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_campaign_order_idx ON ledger(campaign_id, order_id)
+  WHERE campaign_id IS NOT NULL;
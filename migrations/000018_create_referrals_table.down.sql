@@ -0,0 +1,4 @@
+ALTER TABLE ledger DROP COLUMN IF EXISTS referral_id;
+DROP TABLE IF EXISTS referrals;
+ALTER TABLE users DROP COLUMN IF EXISTS registered_ip;
+ALTER TABLE users DROP COLUMN IF EXISTS referral_code;
\ No newline at end of file
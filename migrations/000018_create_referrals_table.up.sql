@@ -0,0 +1,23 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code VARCHAR(16);
+UPDATE users SET referral_code = upper(substr(md5(random()::text || id::text), 1, 10)) WHERE referral_code IS NULL;
+ALTER TABLE users ALTER COLUMN referral_code SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_referral_code_idx ON users(referral_code);
+
+-- Used to tell self-referrals, empty unless the trusted client address header is configured
+ALTER TABLE users ADD COLUMN IF NOT EXISTS registered_ip VARCHAR(45) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS referrals(
+  id SERIAL PRIMARY KEY,
+  referrer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  referee_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+  status VARCHAR(16) NOT NULL, -- PENDING, REWARDED or REJECTED
+  reason VARCHAR(32) NOT NULL DEFAULT '', -- why the referral is rejected
+  order_id VARCHAR(128), -- the first PROCESSED order of the referee
+  reward NUMERIC(8, 2) NOT NULL DEFAULT 0, -- credited to each of the users
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  rewarded_at TIMESTAMPTZ,
+  CHECK (referrer_id <> referee_id)
+);
+CREATE INDEX IF NOT EXISTS referrals_referrer_id_idx ON referrals(referrer_id, created_at);
+
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS referral_id INTEGER REFERENCES referrals(id) ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE ledger DROP COLUMN IF EXISTS voucher_redemption_id;
+DROP TABLE IF EXISTS voucher_redemptions;
+DROP TABLE IF EXISTS vouchers;
+DROP TABLE IF EXISTS voucher_batches;
\ No newline at end of file
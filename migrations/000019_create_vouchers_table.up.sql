@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS voucher_batches(
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(128) NOT NULL,
+  value NUMERIC(8, 2) NOT NULL CHECK (value > 0),
+  usage_limit INTEGER NOT NULL CHECK (usage_limit > 0), -- redemptions per code
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS vouchers(
+  code VARCHAR(32) PRIMARY KEY,
+  batch_id INTEGER NOT NULL REFERENCES voucher_batches(id) ON DELETE CASCADE,
+  used INTEGER NOT NULL DEFAULT 0 CHECK (used >= 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS vouchers_batch_id_idx ON vouchers(batch_id);
+
+CREATE TABLE IF NOT EXISTS voucher_redemptions(
+  id SERIAL PRIMARY KEY,
+  code VARCHAR(32) NOT NULL REFERENCES vouchers(code) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  sum NUMERIC(8, 2) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (code, user_id)
+);
+
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS voucher_redemption_id INTEGER
+  REFERENCES voucher_redemptions(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+-- Keys of requests which died in progress are taken over once the lease is over
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+UPDATE idempotency_keys SET locked_until = created_at WHERE status_code IS NULL;
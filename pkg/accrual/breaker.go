@@ -0,0 +1,168 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrAccrualUnavailable = errors.New("accrual: service is unavailable, circuit is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerStats struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at"`
+}
+
+type iClient interface {
+	GetOrderAccrual(ctx context.Context, orderNum string) (*OrderAccrual, error)
+	RetryPolicy() RetryPolicy
+}
+
+// Circuit breaker around the accrual client.
+// After `failureThreshold` consecutive failures the circuit opens and all calls
+// fail fast with `ErrAccrualUnavailable`. After `cooldown` it's half-open:
+// up to `halfOpenProbes` calls go through, the first success closes the circuit
+// and a failure opens it again.
+type circuitBreaker struct {
+	iClient
+	failureThreshold int
+	cooldown         time.Duration
+	halfOpenProbes   int
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	probes   int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(c iClient, failureThreshold int, cooldown time.Duration, halfOpenProbes int) *circuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	if halfOpenProbes < 1 {
+		halfOpenProbes = 1
+	}
+	return &circuitBreaker{
+		iClient:          c,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		halfOpenProbes:   halfOpenProbes,
+	}
+}
+
+func (b *circuitBreaker) GetOrderAccrual(ctx context.Context, orderNum string) (*OrderAccrual, error) {
+	allowed, probe := b.allow()
+	if !allowed {
+		return nil, ErrAccrualUnavailable
+	}
+	orderAccrual, err := b.iClient.GetOrderAccrual(ctx, orderNum)
+	b.record(ctx, err, probe)
+	return orderAccrual, err
+}
+
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.updateState()
+	return b.state
+}
+
+func (b *circuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.updateState()
+	return BreakerStats{
+		State:    b.state.String(),
+		Failures: b.failures,
+		OpenedAt: b.openedAt,
+	}
+}
+
+// Reports whether the call may go through and whether it's a half-open probe.
+func (b *circuitBreaker) allow() (allowed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.updateState()
+	switch b.state {
+	case BreakerOpen:
+		return false, false
+	case BreakerHalfOpen:
+		if b.probes >= b.halfOpenProbes {
+			return false, false
+		}
+		b.probes++
+		return true, true
+	}
+	return true, false
+}
+
+func (b *circuitBreaker) record(ctx context.Context, err error, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Cancelled calls tell nothing about the accrual system, but a cancelled probe
+	// gives its slot back, otherwise the circuit would stay half-open forever
+	if ctx.Err() != nil {
+		if probe && b.state == BreakerHalfOpen && b.probes > 0 {
+			b.probes--
+		}
+		return
+	}
+
+	if !isBreakerFailure(err) {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probes = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.failureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.probes = 0
+	}
+}
+
+// Moves the open circuit to half-open once the cooldown is over. Must be called under lock.
+func (b *circuitBreaker) updateState() {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+}
+
+// Network and server errors mean the accrual system is in trouble, and so do
+// malformed responses like unexpected statuses or HTML error pages of a broken proxy.
+// Unknown orders and rate limiting are normal answers of a working system.
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return !errors.Is(err, ErrOrderNotRegistered) &&
+		!errors.Is(err, ErrRateLimited)
+}
@@ -0,0 +1,163 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type stubClient struct {
+	err   error
+	calls int
+}
+
+func (c *stubClient) GetOrderAccrual(ctx context.Context, orderNum string) (*OrderAccrual, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &OrderAccrual{Order: orderNum}, nil
+}
+
+func (c *stubClient) RetryPolicy() RetryPolicy {
+	return RetryPolicy{}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	errNetwork := errors.New("connection refused")
+
+	// Each step sets the client's error, calls the breaker and checks the result and the state after it
+	type step struct {
+		err       error
+		wait      bool // sleep past the cooldown before the call
+		wantErr   error
+		wantState BreakerState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after consecutive failures",
+			steps: []step{
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerClosed},
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerOpen},
+				{err: nil, wantErr: ErrAccrualUnavailable, wantState: BreakerOpen},
+			},
+		},
+		{
+			name: "success resets the failure count",
+			steps: []step{
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerClosed},
+				{err: nil, wantState: BreakerClosed},
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "normal answers aren't failures",
+			steps: []step{
+				{err: ErrOrderNotRegistered, wantErr: ErrOrderNotRegistered, wantState: BreakerClosed},
+				{err: fmt.Errorf("retry later: %w", ErrRateLimited), wantErr: ErrRateLimited, wantState: BreakerClosed},
+				{err: ErrOrderNotRegistered, wantErr: ErrOrderNotRegistered, wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "malformed responses are failures",
+			steps: []step{
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerClosed},
+				{err: fmt.Errorf("%w: unexpected status 404", ErrMalformedResponse), wantErr: ErrMalformedResponse, wantState: BreakerOpen},
+			},
+		},
+		{
+			name: "half-open probe success closes",
+			steps: []step{
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerClosed},
+				{err: ErrServerError, wantErr: ErrServerError, wantState: BreakerOpen},
+				{err: nil, wait: true, wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "half-open probe failure opens again",
+			steps: []step{
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerClosed},
+				{err: errNetwork, wantErr: errNetwork, wantState: BreakerOpen},
+				{err: errNetwork, wait: true, wantErr: errNetwork, wantState: BreakerOpen},
+				{err: nil, wantErr: ErrAccrualUnavailable, wantState: BreakerOpen},
+			},
+		},
+	}
+
+	const cooldown = 20 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &stubClient{}
+			b := NewCircuitBreaker(client, 2, cooldown, 1)
+			for i, s := range tt.steps {
+				if s.wait {
+					time.Sleep(cooldown)
+					if got := b.State(); got != BreakerHalfOpen {
+						t.Fatalf("step %d: state after cooldown = %v, want %v", i, got, BreakerHalfOpen)
+					}
+				}
+				client.err = s.err
+				_, err := b.GetOrderAccrual(context.Background(), "12345678903")
+				if s.wantErr == nil && err != nil || s.wantErr != nil && !errors.Is(err, s.wantErr) {
+					t.Fatalf("step %d: error = %v, want %v", i, err, s.wantErr)
+				}
+				if got := b.State(); got != s.wantState {
+					t.Fatalf("step %d: state = %v, want %v", i, got, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	client := &stubClient{err: errors.New("connection refused")}
+	b := NewCircuitBreaker(client, 1, 0, 2)
+
+	// Zero cooldown: the opened circuit is half-open right away
+	b.record(context.Background(), client.err, false)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("state = %v, want %v", got, BreakerHalfOpen)
+	}
+	for i, want := range []bool{true, true, false} {
+		if got, _ := b.allow(); got != want {
+			t.Errorf("probe %d: allow() = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestCircuitBreakerCancelledProbeFreesSlot(t *testing.T) {
+	client := &stubClient{err: errors.New("connection refused")}
+	b := NewCircuitBreaker(client, 1, 0, 1)
+	b.record(context.Background(), client.err, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := b.GetOrderAccrual(ctx, "12345678903"); errors.Is(err, ErrAccrualUnavailable) {
+			t.Fatalf("cancelled probe %d was rejected, the slot of the previous one wasn't freed", i)
+		}
+	}
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Errorf("state = %v, want %v", got, BreakerHalfOpen)
+	}
+	if client.calls != 3 {
+		t.Errorf("client was called %d times, want 3", client.calls)
+	}
+}
+
+func TestCircuitBreakerIgnoresCancelledCalls(t *testing.T) {
+	client := &stubClient{err: errors.New("connection refused")}
+	b := NewCircuitBreaker(client, 1, time.Minute, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _ = b.GetOrderAccrual(ctx, "12345678903")
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("state = %v, want %v", got, BreakerClosed)
+	}
+}
@@ -1,7 +1,71 @@
 package accrual
 
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
 type OrderAccrual struct {
 	Order   string
 	Status  string
-	Accrual float32
+	Accrual money.Amount
+}
+
+// Statuses reported by the accrual system.
+const (
+	REGISTERED = "REGISTERED"
+	INVALID    = "INVALID"
+	PROCESSING = "PROCESSING"
+	PROCESSED  = "PROCESSED"
+)
+
+var (
+	ErrOrderNotRegistered = errors.New("accrual: order is not registered")
+	ErrRateLimited        = errors.New("accrual: too many requests")
+	ErrServerError        = errors.New("accrual: server error")
+	ErrMalformedResponse  = errors.New("accrual: malformed response")
+)
+
+// Returned on `429 Too Many Requests`, matches `ErrRateLimited` with `errors.Is`.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+func (oa *OrderAccrual) validate(orderNum string) error {
+	if oa.Order != orderNum {
+		return fmt.Errorf("%w: got order `%s` instead of `%s`", ErrMalformedResponse, oa.Order, orderNum)
+	}
+	switch oa.Status {
+	case REGISTERED, INVALID, PROCESSING, PROCESSED:
+	default:
+		return fmt.Errorf("%w: unknown status `%s`", ErrMalformedResponse, oa.Status)
+	}
+	if err := oa.ValidateAccrual(); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+	return nil
+}
+
+var ErrBadAccrual = errors.New("accrual: bad accrual")
+
+// Only PROCESSED orders have an accrual and it's never negative.
+func (oa *OrderAccrual) ValidateAccrual() error {
+	if oa.Accrual < 0 {
+		return fmt.Errorf("%w: `%s` is negative", ErrBadAccrual, oa.Accrual)
+	}
+	if oa.Accrual != 0 && oa.Status != PROCESSED {
+		return fmt.Errorf("%w: %s order can't have accrual `%s`", ErrBadAccrual, oa.Status, oa.Accrual)
+	}
+	return nil
 }
@@ -0,0 +1,33 @@
+package accrual
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+func TestValidateAccrual(t *testing.T) {
+	tests := []struct {
+		status  string
+		accrual money.Amount
+		wantErr bool
+	}{
+		{status: PROCESSED, accrual: 500},
+		{status: PROCESSED, accrual: 0},
+		{status: REGISTERED, accrual: 0},
+		{status: PROCESSING, accrual: 0},
+		{status: INVALID, accrual: 0},
+		{status: PROCESSED, accrual: -1, wantErr: true},
+		{status: PROCESSING, accrual: 500, wantErr: true},
+		{status: INVALID, accrual: 500, wantErr: true},
+		{status: REGISTERED, accrual: -500, wantErr: true},
+	}
+	for _, tt := range tests {
+		oa := &OrderAccrual{Order: "12345678903", Status: tt.status, Accrual: tt.accrual}
+		err := oa.ValidateAccrual()
+		if tt.wantErr != errors.Is(err, ErrBadAccrual) || !tt.wantErr && err != nil {
+			t.Errorf("ValidateAccrual(%s, %s) = %v, want error %v", tt.status, tt.accrual, err, tt.wantErr)
+		}
+	}
+}
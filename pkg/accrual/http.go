@@ -3,6 +3,7 @@ package accrual
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -11,37 +12,43 @@ import (
 )
 
 type accrualHTTP struct {
-	baseURL      string
-	maxAttempts  int
-	reqTimeout   time.Duration
-	pollInterval time.Duration
-	client       *http.Client
+	baseURL     string
+	reqTimeout  time.Duration
+	retryPolicy RetryPolicy
+	client      *http.Client
+	throttle    *throttle
 }
 
-func NewHTTPClient(addr string, maxAttempts int, reqTimeout time.Duration, pollInterval time.Duration) *accrualHTTP {
+func NewHTTPClient(addr string, reqTimeout time.Duration, retryPolicy RetryPolicy) *accrualHTTP {
 	c := http.Client{
 		Timeout: reqTimeout,
 	}
 
 	return &accrualHTTP{
-		client:       &c,
-		baseURL:      addr,
-		maxAttempts:  maxAttempts,
-		reqTimeout:   reqTimeout,
-		pollInterval: pollInterval,
+		client:      &c,
+		baseURL:     addr,
+		reqTimeout:  reqTimeout,
+		retryPolicy: retryPolicy,
+		throttle:    &throttle{},
 	}
 }
 
-func (a *accrualHTTP) MaxAttempts() int {
-	return a.maxAttempts
-}
-
-func (a *accrualHTTP) Interval() time.Duration {
-	return a.pollInterval
+func (a *accrualHTTP) RetryPolicy() RetryPolicy {
+	return a.retryPolicy
 }
 
 func (a *accrualHTTP) GetOrderAccrual(ctx context.Context, orderNum string) (orderAccrual *OrderAccrual, err error) {
-	resp, err := a.client.Get(a.baseURL + "/api/orders/" + orderNum)
+	if err = a.throttle.Wait(ctx); err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+"/api/orders/"+orderNum, nil)
+	if err != nil {
+		logger.Log(ctx).Errorf("order: failed building request to accrual, %v", err)
+		return
+	}
+
+	resp, err := a.client.Do(req)
 	if err != nil {
 		logger.Log(ctx).Errorf("order: failed sending request to accrual, %v", err)
 		return
@@ -54,11 +61,28 @@ func (a *accrualHTTP) GetOrderAccrual(ctx context.Context, orderNum string) (ord
 		return
 	}
 
-	err = json.Unmarshal(body, &orderAccrual)
-	if err != nil {
-		logger.Log(ctx).Errorf("order: failed parsing response from accrual, %v", err)
-		return
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return nil, ErrOrderNotRegistered
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		a.throttle.Pause(retryAfter)
+		a.throttle.SetLimit(parseRPMLimit(body))
+		logger.Log(ctx).Warnf("order: accrual rate limit exceeded, pausing for %s", retryAfter)
+		return nil, &RateLimitError{RetryAfter: retryAfter}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: status %d", ErrServerError, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrMalformedResponse, resp.StatusCode)
+	}
+
+	orderAccrual = new(OrderAccrual)
+	if err = json.Unmarshal(body, orderAccrual); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+	if err = orderAccrual.validate(orderNum); err != nil {
+		return nil, err
 	}
 
-	return
+	return orderAccrual, nil
 }
@@ -0,0 +1,53 @@
+package accrual
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Caps delays of policies without `MaxDelay`.
+const defaultMaxDelay = 24 * time.Hour
+
+// Exponential backoff with jitter for polling the accrual system.
+// The delay before attempt `n` is `BaseDelay * Multiplier^n` capped by `MaxDelay`,
+// then randomly spread by ±`Jitter` (a fraction of the delay).
+type RetryPolicy struct {
+	BaseDelay   time.Duration
+	Multiplier  float64
+	MaxDelay    time.Duration // 0 means `defaultMaxDelay`
+	Jitter      float64       // 0..1
+	MaxElapsed  time.Duration // 0 means no limit
+	MaxAttempts int           // 0 means no limit
+}
+
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+	// The power grows past `time.Duration` fast, so it's clamped as a float
+	delay := float64(p.BaseDelay) * math.Pow(mult, float64(attempt))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// Reports whether there should be no more attempts.
+func (p RetryPolicy) Exhausted(attempts int, elapsed time.Duration) bool {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return true
+	}
+	return p.MaxElapsed > 0 && elapsed >= p.MaxElapsed
+}
@@ -0,0 +1,99 @@
+package accrual
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{
+			name:    "first attempt",
+			policy:  RetryPolicy{BaseDelay: time.Second, Multiplier: 2, MaxDelay: time.Minute},
+			attempt: 0,
+			want:    time.Second,
+		},
+		{
+			name:    "grows exponentially",
+			policy:  RetryPolicy{BaseDelay: time.Second, Multiplier: 2, MaxDelay: time.Minute},
+			attempt: 3,
+			want:    8 * time.Second,
+		},
+		{
+			name:    "capped by max delay",
+			policy:  RetryPolicy{BaseDelay: time.Second, Multiplier: 2, MaxDelay: time.Minute},
+			attempt: 10,
+			want:    time.Minute,
+		},
+		{
+			name:    "no max delay uses the default",
+			policy:  RetryPolicy{BaseDelay: time.Second, Multiplier: 2},
+			attempt: 30,
+			want:    defaultMaxDelay,
+		},
+		{
+			name:    "huge attempt doesn't overflow",
+			policy:  RetryPolicy{BaseDelay: time.Second, Multiplier: 10, MaxDelay: time.Hour},
+			attempt: math.MaxInt32,
+			want:    time.Hour,
+		},
+		{
+			name:    "multiplier below one is constant",
+			policy:  RetryPolicy{BaseDelay: time.Second, Multiplier: 0.5, MaxDelay: time.Minute},
+			attempt: 5,
+			want:    time.Second,
+		},
+		{
+			name:    "zero base delay",
+			policy:  RetryPolicy{Multiplier: 2, MaxDelay: time.Minute},
+			attempt: 5,
+			want:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.attempt); got != tt.want {
+				t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Second, Multiplier: 1, MaxDelay: time.Minute, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		got := p.Delay(1)
+		if got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("Delay(1) = %v, want within 10s ± 20%%", got)
+		}
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+		elapsed  time.Duration
+		want     bool
+	}{
+		{name: "no limits", policy: RetryPolicy{}, attempts: 1000, elapsed: 1000 * time.Hour, want: false},
+		{name: "below max attempts", policy: RetryPolicy{MaxAttempts: 3}, attempts: 2, want: false},
+		{name: "max attempts", policy: RetryPolicy{MaxAttempts: 3}, attempts: 3, want: true},
+		{name: "below max elapsed", policy: RetryPolicy{MaxElapsed: time.Hour}, elapsed: time.Minute, want: false},
+		{name: "max elapsed", policy: RetryPolicy{MaxElapsed: time.Hour}, elapsed: time.Hour, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Exhausted(tt.attempts, tt.elapsed); got != tt.want {
+				t.Errorf("Exhausted(%d, %v) = %v, want %v", tt.attempts, tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package accrual
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header with the HMAC-SHA256 signature of a pushed accrual update body,
+// formatted as `sha256=<hex>`.
+const SignatureHeader = "X-Accrual-Signature"
+
+// Header with the Unix time the update was signed at. Pushed updates sign
+// `<timestamp>.<body>`, so a captured update can't be replayed once it's stale.
+const TimestampHeader = "X-Accrual-Timestamp"
+
+// Timestamped signatures further than this from now are rejected.
+const MaxSignatureAge = 5 * time.Minute
+
+const signaturePrefix = "sha256="
+
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the signature in constant time. An empty secret never verifies.
+func VerifySignature(secret, body []byte, signature string) bool {
+	if len(secret) == 0 || !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// Signs the body together with the time it's sent at, see `TimestampHeader`.
+func SignAt(secret, body []byte, at time.Time) (signature, timestamp string) {
+	timestamp = strconv.FormatInt(at.Unix(), 10)
+	return Sign(secret, timestampedPayload(timestamp, body)), timestamp
+}
+
+// Checks the signature of the body signed with `SignAt` and that the timestamp
+// is within `MaxSignatureAge` of `now` either way.
+func VerifyTimestamped(secret, body []byte, timestamp, signature string, now time.Time) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(sec, 0))
+	if age > MaxSignatureAge || age < -MaxSignatureAge {
+		return false
+	}
+	return VerifySignature(secret, timestampedPayload(timestamp, body), signature)
+}
+
+func timestampedPayload(timestamp string, body []byte) []byte {
+	payload := make([]byte, 0, len(timestamp)+1+len(body))
+	payload = append(payload, timestamp...)
+	payload = append(payload, '.')
+	return append(payload, body...)
+}
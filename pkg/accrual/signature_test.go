@@ -0,0 +1,70 @@
+package accrual
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`)
+	valid := Sign(secret, body)
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{name: "valid", secret: secret, body: body, signature: valid, want: true},
+		{name: "other secret", secret: []byte("other"), body: body, signature: valid},
+		{name: "empty secret", secret: nil, body: body, signature: Sign(nil, body)},
+		{name: "tampered body", secret: secret, body: []byte(`{"order":"12345678903","status":"PROCESSED","accrual":5000}`), signature: valid},
+		{name: "no prefix", secret: secret, body: body, signature: valid[len(signaturePrefix):]},
+		{name: "not hex", secret: secret, body: body, signature: signaturePrefix + "zz"},
+		{name: "truncated", secret: secret, body: body, signature: valid[:len(valid)-2]},
+		{name: "empty", secret: secret, body: body, signature: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.secret, tt.body, tt.signature); got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTimestamped(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`)
+	now := time.Unix(1700000000, 0)
+	signature, timestamp := SignAt(secret, body, now)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		timestamp string
+		signature string
+		now       time.Time
+		want      bool
+	}{
+		{name: "valid", body: body, timestamp: timestamp, signature: signature, now: now, want: true},
+		{name: "within max age", body: body, timestamp: timestamp, signature: signature, now: now.Add(MaxSignatureAge), want: true},
+		{name: "stale", body: body, timestamp: timestamp, signature: signature, now: now.Add(MaxSignatureAge + time.Second)},
+		{name: "from the future", body: body, timestamp: timestamp, signature: signature, now: now.Add(-MaxSignatureAge - time.Second)},
+		{name: "timestamp replaced", body: body, timestamp: strconv.FormatInt(now.Unix()+1, 10), signature: signature, now: now},
+		{name: "bad timestamp", body: body, timestamp: "yesterday", signature: signature, now: now},
+		{name: "empty timestamp", body: body, timestamp: "", signature: signature, now: now},
+		{name: "body only signature", body: body, timestamp: timestamp, signature: Sign(secret, body), now: now},
+		{name: "tampered body", body: []byte(`{}`), timestamp: timestamp, signature: signature, now: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyTimestamped(secret, tt.body, tt.timestamp, tt.signature, tt.now); got != tt.want {
+				t.Errorf("VerifyTimestamped() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
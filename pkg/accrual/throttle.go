@@ -0,0 +1,113 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultRetryAfter = 60 * time.Second
+
+var rpmLimitRe = regexp.MustCompile(`(\d+) requests per minute`)
+
+// Process-wide throttle shared by all pollers using the same client.
+// When the accrual system answers `429` everybody waits for `Retry-After`,
+// afterwards requests are spread according to the advertised per-minute limit.
+type throttle struct {
+	mu          sync.Mutex
+	pausedUntil time.Time
+	next        time.Time     // earliest time the next request is allowed at
+	interval    time.Duration // min gap between requests, 0 means no limit
+}
+
+// Blocks until the caller is allowed to send a request or `ctx` is done.
+// The slot is taken only when the wait is over, so callers which give up
+// don't use up the limit and don't delay the rest.
+func (t *throttle) Wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		now := time.Now()
+		at := now
+		if t.pausedUntil.After(at) {
+			at = t.pausedUntil
+		}
+		if t.next.After(at) {
+			at = t.next
+		}
+		if !at.After(now) {
+			t.next = now.Add(t.interval)
+			t.mu.Unlock()
+			return nil
+		}
+		t.mu.Unlock()
+
+		// Somebody else may take the slot meanwhile, then it's checked again
+		if err := sleep(ctx, at.Sub(now)); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Stops all requests for `d`.
+func (t *throttle) Pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+}
+
+// Adapts the pace of requests to "No more than N requests per minute".
+func (t *throttle) SetLimit(perMinute int) {
+	if perMinute <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interval = time.Minute / time.Duration(perMinute)
+}
+
+// Parses `Retry-After` which is either a number of seconds or an HTTP date.
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return defaultRetryAfter
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(h); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// Gets N from the `429` response body like "No more than N requests per minute allowed".
+func parseRPMLimit(body []byte) int {
+	m := rpmLimitRe.FindSubmatch(body)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 1200 requests per minute is one every 50ms.
+const testRPM, testInterval = 1200, 50 * time.Millisecond
+
+func TestThrottleNoLimit(t *testing.T) {
+	th := &throttle{}
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := th.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > testInterval {
+		t.Errorf("100 requests without a limit took %v", elapsed)
+	}
+}
+
+func TestThrottleSetLimitSpreadsRequests(t *testing.T) {
+	th := &throttle{}
+	th.SetLimit(testRPM)
+	th.SetLimit(0) // unknown limits keep the last one
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := th.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first request goes right away, two more wait for their slots
+	if elapsed := time.Since(start); elapsed < 2*testInterval {
+		t.Errorf("3 requests took %v, want at least %v", elapsed, 2*testInterval)
+	}
+}
+
+func TestThrottlePause(t *testing.T) {
+	th := &throttle{}
+	th.Pause(testInterval)
+	th.Pause(time.Millisecond) // a shorter pause doesn't cut the longer one
+
+	start := time.Now()
+	if err := th.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < testInterval {
+		t.Errorf("request was sent after %v of the %v pause", elapsed, testInterval)
+	}
+}
+
+func TestThrottleWaitCancelled(t *testing.T) {
+	th := &throttle{}
+	th.Pause(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := th.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestThrottleCancelledWaitersKeepSlots(t *testing.T) {
+	th := &throttle{}
+	th.SetLimit(testRPM)
+	if err := th.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiters which give up before their slot mustn't push the next request back
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_ = th.Wait(ctx)
+	}
+
+	start := time.Now()
+	if err := th.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*testInterval {
+		t.Errorf("next request waited %v, want about %v", elapsed, testInterval)
+	}
+}
@@ -1,15 +1,101 @@
 package balance
 
-import "time"
+import (
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/expiry"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Statuses of holds.
+const (
+	HoldActive   = "ACTIVE"   // points are reserved
+	HoldCaptured = "CAPTURED" // points are withdrawn
+	HoldReleased = "RELEASED" // points are returned by the partner
+	HoldExpired  = "EXPIRED"  // points are returned because the hold wasn't captured in time
+)
 
 type Withdraw struct {
-	Order       string    `json:"order"`
-	UserID      string    `json:"-"`
-	Sum         float32   `json:"sum"`
-	ProcessedAt time.Time `json:"processed_at"`
+	Order       string       `json:"order"`
+	UserID      string       `json:"-"`
+	Sum         money.Amount `json:"sum"`
+	Reversed    money.Amount `json:"reversed,omitempty"` // returned to the user by reversals
+	ProcessedAt time.Time    `json:"processed_at"`
 }
 
 type Balance struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current      money.Amount       `json:"current"`
+	Held         money.Amount       `json:"held"`
+	Withdrawn    money.Amount       `json:"withdrawn"`
+	ExpiringSoon []*expiry.Expiring `json:"expiring_soon"` // part of `Current` which expires soon
+}
+
+// Points reserved for an order at checkout. They are not available for
+// spending until the hold is released or expires, and become a withdrawal
+// once the hold is captured.
+type Hold struct {
+	ID        int64        `json:"id"`
+	Order     string       `json:"order"`
+	UserID    string       `json:"-"`
+	Sum       money.Amount `json:"sum"`
+	Status    string       `json:"status"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// Points of a withdrawal returned to the user, e.g. when the purchase is cancelled.
+// A withdrawal may be reversed in parts, but not for more than its sum.
+type Reversal struct {
+	ID        int64        `json:"id"`
+	Order     string       `json:"order"`
+	UserID    string       `json:"-"`
+	Sum       money.Amount `json:"sum"` // zero in a request means everything not reversed yet
+	Reason    string       `json:"reason,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// A change of the user balance. Entry types are the kinds of ledger postings.
+type HistoryEntry struct {
+	ID           int64        `json:"-"`
+	Type         string       `json:"type"`
+	Order        string       `json:"order,omitempty"`
+	Counterparty string       `json:"counterparty,omitempty"` // login of the other side of a transfer
+	Amount       money.Amount `json:"amount"`                 // negative if points are taken from the balance
+	Balance      money.Amount `json:"balance"`                // the balance right after the entry
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// Entries are listed from the newest, the next page starts after `After`.
+type HistoryFilter struct {
+	From  time.Time // inclusive, zero means no bound
+	To    time.Time // exclusive, zero means no bound
+	Types []string  // empty means all
+	After *HistoryCursor
+	Limit int
+}
+
+// Position of the last entry on the page.
+type HistoryCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+type HistoryPage struct {
+	Entries    []*HistoryEntry `json:"entries"`
+	NextCursor string          `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// Points sent by one user to another.
+type Transfer struct {
+	ID        int64        `json:"id"`
+	From      string       `json:"from"` // login of the sender
+	To        string       `json:"to"`   // login of the recipient
+	Sum       money.Amount `json:"sum"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Zero means no limit.
+type TransferLimits struct {
+	Min   money.Amount // the smallest transfer
+	Daily money.Amount // max sum a user can send during 24 hours
 }
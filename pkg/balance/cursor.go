@@ -0,0 +1,35 @@
+package balance
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursors are opaque to clients: base64 of `<created_at in µs>:<entry id>`.
+func (c *HistoryCursor) encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseHistoryCursor(s string) (*HistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadCursor, err)
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errBadCursor
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadCursor, err)
+	}
+	entryID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadCursor, err)
+	}
+	return &HistoryCursor{CreatedAt: time.UnixMicro(us), ID: entryID}, nil
+}
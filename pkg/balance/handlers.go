@@ -3,17 +3,33 @@ package balance
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
 
 	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ordernum"
 )
 
 type iService interface {
 	GetUserBalance(ctx context.Context) (*Balance, error)
-	Withdraw(ctx context.Context, w *Withdraw) (float32, error)
+	Withdraw(ctx context.Context, w *Withdraw) (money.Amount, error)
 	Withdrawals(ctx context.Context) ([]*Withdraw, error)
+	CreateHold(ctx context.Context, h *Hold) (*Hold, error)
+	CaptureHold(ctx context.Context, holdID int64) (*Withdraw, error)
+	ReleaseHold(ctx context.Context, holdID int64) (*Hold, error)
+	Holds(ctx context.Context) ([]*Hold, error)
+	ReverseWithdrawal(ctx context.Context, rev *Reversal) (*Reversal, error)
+	History(ctx context.Context, f *HistoryFilter) (*HistoryPage, error)
+	Transfer(ctx context.Context, t *Transfer) (*Transfer, error)
+	Transfers(ctx context.Context) ([]*Transfer, error)
 }
 
 type handler struct {
@@ -48,13 +64,32 @@ func (h *handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var numErr *ordernum.ValidationError
+	if errors.As(ordernum.Validate(withdraw.Order), &numErr) {
+		logger.Log(r.Context()).Errorf("balance/handlers: %v", numErr)
+		common.WriteValidationMsg(w, "order number is not valid", "order", numErr.Reason)
+		return
+	}
+
 	newBalance, err := h.service.Withdraw(r.Context(), withdraw)
+	if errors.Is(err, errBadSum) {
+		common.WriteMsg(w, "sum must be positive", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errInsufficientFunds) {
+		common.WriteMsg(w, "insufficient funds", http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, errWithdrawalExists) {
+		common.WriteMsg(w, "order is already paid with points", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		common.WriteMsg(w, "failed to withdraw from user balance", http.StatusInternalServerError)
 		return
 	}
 
-	msg := fmt.Sprintf(`successfully withdrawn %f from %s; current balance: %f`, withdraw.Sum, withdraw.Order, newBalance)
+	msg := fmt.Sprintf(`successfully withdrawn %s from %s; current balance: %s`, withdraw.Sum, withdraw.Order, newBalance)
 	common.WriteMsg(w, msg, http.StatusOK)
 }
 
@@ -69,3 +104,240 @@ func (h *handler) Withdrawals(w http.ResponseWriter, r *http.Request) {
 
 	common.WriteRespJSON(w, withdrawals)
 }
+
+func (h *handler) Transfer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	transfer := new(Transfer)
+	err := json.NewDecoder(r.Body).Decode(transfer)
+	if err != nil || transfer.To == "" {
+		logger.Log(r.Context()).Errorf("can't parse request body as transfer: %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	sent, err := h.service.Transfer(r.Context(), transfer)
+	switch {
+	case errors.Is(err, errBadSum):
+		common.WriteMsg(w, "sum must be positive", http.StatusBadRequest)
+	case errors.Is(err, errTransferTooSmall):
+		common.WriteMsg(w, "sum is less than the minimal transfer", http.StatusBadRequest)
+	case errors.Is(err, errTransferToSelf):
+		common.WriteMsg(w, "can't transfer to yourself", http.StatusBadRequest)
+	case errors.Is(err, errRecipientNotFound):
+		common.WriteMsg(w, "recipient not found", http.StatusNotFound)
+	case errors.Is(err, errInsufficientFunds):
+		common.WriteMsg(w, "insufficient funds", http.StatusPaymentRequired)
+	case errors.Is(err, errDailyLimitExceeded):
+		common.WriteMsg(w, "daily transfer limit exceeded", http.StatusForbidden)
+	case err != nil:
+		common.WriteMsg(w, "failed to transfer points", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		common.WriteRespJSON(w, sent)
+	}
+}
+
+func (h *handler) Transfers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	transfers, err := h.service.Transfers(r.Context())
+	if err != nil {
+		common.WriteMsg(w, "can't get user transfers", http.StatusInternalServerError)
+		return
+	}
+	common.WriteRespJSON(w, transfers)
+}
+
+// Query params: `from` and `to` as RFC 3339 or `2006-01-02`, `type` as a comma
+// separated list of entry types, `limit` and `cursor` from the previous page.
+func (h *handler) History(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	f, err := parseHistoryFilter(r)
+	if err != nil {
+		logger.Log(r.Context()).Errorf("balance/handlers: %v", err)
+		common.WriteMsg(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.service.History(r.Context(), f)
+	switch {
+	case errors.Is(err, errBadHistoryFilter):
+		common.WriteMsg(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		common.WriteMsg(w, "can't get balance history", http.StatusInternalServerError)
+	default:
+		common.WriteRespJSON(w, page)
+	}
+}
+
+func parseHistoryFilter(r *http.Request) (*HistoryFilter, error) {
+	query := r.URL.Query()
+	f := &HistoryFilter{}
+	var err error
+
+	if f.From, err = parseHistoryTime(query.Get("from")); err != nil {
+		return nil, fmt.Errorf("bad `from`: %w", err)
+	}
+	if f.To, err = parseHistoryTime(query.Get("to")); err != nil {
+		return nil, fmt.Errorf("bad `to`: %w", err)
+	}
+	for _, types := range query["type"] {
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.ToUpper(strings.TrimSpace(t)); t != "" {
+				f.Types = append(f.Types, t)
+			}
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if f.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, fmt.Errorf("bad `limit`: %w", err)
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if f.After, err = parseHistoryCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// Support API: returns points of a cancelled purchase, the whole withdrawal or a part of it.
+func (h *handler) ReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rev := new(Reversal)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(rev); err != nil {
+			logger.Log(r.Context()).Errorf("can't parse request body as reversal: %v", err)
+			common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+			return
+		}
+	}
+	rev.Order = mux.Vars(r)["order"]
+
+	reversed, err := h.service.ReverseWithdrawal(r.Context(), rev)
+	switch {
+	case errors.Is(err, errBadSum):
+		common.WriteMsg(w, "sum can't be negative", http.StatusBadRequest)
+	case errors.Is(err, errWithdrawalNotFound):
+		common.WriteMsg(w, "order is not paid with points", http.StatusNotFound)
+	case errors.Is(err, errReversalTooBig):
+		common.WriteMsg(w, "sum exceeds the amount left to reverse", http.StatusConflict)
+	case err != nil:
+		common.WriteMsg(w, "failed to reverse withdrawal", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		common.WriteRespJSON(w, reversed)
+	}
+}
+
+func (h *handler) CreateHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	hold := new(Hold)
+	err := json.NewDecoder(r.Body).Decode(hold)
+	if err != nil {
+		logger.Log(r.Context()).Errorf("can't parse request body as hold: %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	var numErr *ordernum.ValidationError
+	if errors.As(ordernum.Validate(hold.Order), &numErr) {
+		logger.Log(r.Context()).Errorf("balance/handlers: %v", numErr)
+		common.WriteValidationMsg(w, "order number is not valid", "order", numErr.Reason)
+		return
+	}
+
+	created, err := h.service.CreateHold(r.Context(), hold)
+	if err != nil {
+		writeHoldError(w, err, "failed to hold user points")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.WriteRespJSON(w, created)
+}
+
+func (h *handler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	holdID, ok := parseHoldID(w, r)
+	if !ok {
+		return
+	}
+
+	withdraw, err := h.service.CaptureHold(r.Context(), holdID)
+	if err != nil {
+		writeHoldError(w, err, "failed to capture hold")
+		return
+	}
+	common.WriteRespJSON(w, withdraw)
+}
+
+func (h *handler) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	holdID, ok := parseHoldID(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := h.service.ReleaseHold(r.Context(), holdID)
+	if err != nil {
+		writeHoldError(w, err, "failed to release hold")
+		return
+	}
+	common.WriteRespJSON(w, hold)
+}
+
+func (h *handler) Holds(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	holds, err := h.service.Holds(r.Context())
+	if err != nil {
+		common.WriteMsg(w, "can't get user holds", http.StatusInternalServerError)
+		return
+	}
+	common.WriteRespJSON(w, holds)
+}
+
+func parseHoldID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	holdID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		common.WriteMsg(w, "bad hold id", http.StatusBadRequest)
+		return 0, false
+	}
+	return holdID, true
+}
+
+func writeHoldError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, errBadSum):
+		common.WriteMsg(w, "sum must be positive", http.StatusBadRequest)
+	case errors.Is(err, errInsufficientFunds):
+		common.WriteMsg(w, "insufficient funds", http.StatusPaymentRequired)
+	case errors.Is(err, errWithdrawalExists):
+		common.WriteMsg(w, "order is already paid with points", http.StatusConflict)
+	case errors.Is(err, errHoldExists):
+		common.WriteMsg(w, "order already has an active hold", http.StatusConflict)
+	case errors.Is(err, errHoldNotActive):
+		common.WriteMsg(w, "hold is already captured, released or expired", http.StatusConflict)
+	case errors.Is(err, errHoldNotFound):
+		common.WriteMsg(w, "hold not found", http.StatusNotFound)
+	default:
+		common.WriteMsg(w, fallback, http.StatusInternalServerError)
+	}
+}
@@ -3,9 +3,20 @@ package balance
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
 )
 
+// Max number of holds expired in one transaction.
+const expireBatchSize = 100
+
+const holdColumns = `id, user_id, order_id, sum, status, created_at, expires_at`
+
 type repo struct {
 	db *sql.DB
 }
@@ -16,36 +27,58 @@ func NewRepo(db *sql.DB) *repo {
 	}
 }
 
-func (r *repo) GetBalance(userID string) (*Balance, error) {
+// Reads the `users` columns which `ledger.Post` keeps in sync with the ledger,
+// so the balance is the same one withdrawals are checked against.
+func (r *repo) GetBalance(ctx context.Context, userID string) (*Balance, error) {
 	bal := &Balance{}
-	row := r.db.QueryRow("SELECT balance, withdrawn FROM users where id=$1", userID)
-	if err := row.Scan(&bal.Current, &bal.Withdrawn); err != nil {
-		return bal, fmt.Errorf("balance: row scan failed: %w", err)
+	err := r.db.QueryRowContext(ctx, `SELECT balance, held, withdrawn FROM users WHERE id = $1`, userID).
+		Scan(&bal.Current, &bal.Held, &bal.Withdrawn)
+	if err != nil {
+		return bal, fmt.Errorf("balance: can't get balance of user `%s`, %w", userID, err)
 	}
 	return bal, nil
 }
 
-func (r *repo) WithdrawFromUserBalance(userID, orderID string, sumToWithdraw float32) (float32, error) {
-	ctx := context.TODO()
+// Debits the user and records the withdrawal atomically. Fails with `errInsufficientFunds`
+// if the balance isn't enough and with `errWithdrawalExists` if the order is already paid.
+func (r *repo) WithdrawFromUserBalance(ctx context.Context, userID, orderID string, sumToWithdraw money.Amount) (money.Amount, error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("balance: failed init withdraw transaction, %w", err)
 	}
 	defer tx.Rollback()
 
-	q := `UPDATE users SET balance=balance-$1, withdrawn=withdrawn+$1
-		    WHERE id = $2 RETURNING balance`
-	var newBalance float32
-	err = tx.QueryRow(q, sumToWithdraw, userID).Scan(&newBalance)
+	// Add record to withdrawals table
+	var withdrawalID int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO withdrawals(user_id, order_id, sum) VALUES($1, $2, $3) RETURNING id`,
+		userID, orderID, sumToWithdraw).Scan(&withdrawalID)
+	if common.IsUniqueViolation(err) {
+		return 0, fmt.Errorf("balance: order `%s`, %w", orderID, errWithdrawalExists)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("balance: failed inserting to `withdrawals` table, %w", err)
+	}
+
+	err = ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:       userID,
+		Kind:         ledger.WITHDRAWAL,
+		Debit:        ledger.UserAccount(userID),
+		Credit:       ledger.AccountWithdrawals,
+		Amount:       sumToWithdraw,
+		OrderID:      orderID,
+		WithdrawalID: withdrawalID,
+	})
+	if errors.Is(err, ledger.ErrInsufficientFunds) {
+		return 0, fmt.Errorf("balance: %v, %w", err, errInsufficientFunds)
+	}
 	if err != nil {
 		return 0, fmt.Errorf("balance: failed withdraw from user balance, %w", err)
 	}
 
-	// Add record to withdrawals table
-	_, err = tx.Exec(`INSERT INTO withdrawals(user_id, order_id, sum) VALUES($1, $2, $3)`,
-		userID, orderID, sumToWithdraw)
+	var newBalance money.Amount
+	err = tx.QueryRowContext(ctx, `SELECT balance FROM users WHERE id = $1`, userID).Scan(&newBalance)
 	if err != nil {
-		return 0, fmt.Errorf("balance: failed inserting to `withdrawals` table, %w", err)
+		return 0, fmt.Errorf("balance: failed getting new balance, %w", err)
 	}
 
 	if err = tx.Commit(); err != nil {
@@ -56,7 +89,10 @@ func (r *repo) WithdrawFromUserBalance(userID, orderID string, sumToWithdraw flo
 }
 
 func (r *repo) GetWithdrawals(userID string) ([]*Withdraw, error) {
-	q := `SELECT order_id, sum, processed_at FROM withdrawals WHERE user_id=$1 ORDER BY processed_at DESC`
+	q := `SELECT w.order_id, w.sum,
+	        COALESCE((SELECT SUM(r.sum) FROM withdrawal_reversals r WHERE r.withdrawal_id = w.id), 0),
+	        w.processed_at
+	      FROM withdrawals w WHERE w.user_id=$1 ORDER BY w.processed_at DESC`
 	rows, err := r.db.Query(q, userID)
 	if err != nil {
 		return nil, err
@@ -69,10 +105,486 @@ func (r *repo) GetWithdrawals(userID string) ([]*Withdraw, error) {
 	withdrawals := []*Withdraw{}
 	for rows.Next() {
 		w := new(Withdraw)
-		if err := rows.Scan(&w.Order, &w.Sum, &w.ProcessedAt); err != nil {
+		if err := rows.Scan(&w.Order, &w.Sum, &w.Reversed, &w.ProcessedAt); err != nil {
 			return nil, fmt.Errorf("scan withdraw row failed: %w", err)
 		}
 		withdrawals = append(withdrawals, w)
 	}
 	return withdrawals, nil
 }
+
+// Moves `sum` from the user balance to the held account for `ttl`.
+// Fails with `errInsufficientFunds` if the balance isn't enough, with `errHoldExists`
+// if the order already has an active hold and with `errWithdrawalExists` if it's already paid.
+func (r *repo) CreateHold(ctx context.Context, userID, orderID string, sum money.Amount, ttl time.Duration) (*Hold, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed init hold transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	var paid bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM withdrawals WHERE order_id = $1)`, orderID).Scan(&paid)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed checking withdrawals of order `%s`, %w", orderID, err)
+	}
+	if paid {
+		return nil, fmt.Errorf("balance: order `%s`, %w", orderID, errWithdrawalExists)
+	}
+
+	q := `INSERT INTO holds(user_id, order_id, sum, expires_at)
+	      VALUES($1, $2, $3, NOW() + make_interval(secs => $4))
+	      RETURNING ` + holdColumns
+	hold, err := scanHold(tx.QueryRowContext(ctx, q, userID, orderID, sum, ttl.Seconds()))
+	if common.IsUniqueViolation(err) {
+		return nil, fmt.Errorf("balance: order `%s`, %w", orderID, errHoldExists)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed inserting to `holds` table, %w", err)
+	}
+
+	err = ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:  userID,
+		Kind:    ledger.HOLD,
+		Debit:   ledger.UserAccount(userID),
+		Credit:  ledger.HeldAccount(userID),
+		Amount:  sum,
+		OrderID: orderID,
+	})
+	if errors.Is(err, ledger.ErrInsufficientFunds) {
+		return nil, fmt.Errorf("balance: %v, %w", err, errInsufficientFunds)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed holding user points, %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("balance: failed committing hold transaction, %w", err)
+	}
+	return hold, nil
+}
+
+// Turns the active hold into a withdrawal. Expired holds can't be captured
+// even if the expiry job hasn't released them yet.
+func (r *repo) CaptureHold(ctx context.Context, userID string, holdID int64) (*Withdraw, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed init capture transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	hold, err := closeHold(ctx, tx, userID, holdID, HoldCaptured)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Withdraw{Order: hold.Order, UserID: userID, Sum: hold.Sum}
+	var withdrawalID int64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO withdrawals(user_id, order_id, sum) VALUES($1, $2, $3) RETURNING id, processed_at`,
+		userID, hold.Order, hold.Sum).Scan(&withdrawalID, &w.ProcessedAt)
+	if common.IsUniqueViolation(err) {
+		return nil, fmt.Errorf("balance: order `%s`, %w", hold.Order, errWithdrawalExists)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed inserting to `withdrawals` table, %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE holds SET withdrawal_id = $1 WHERE id = $2`, withdrawalID, holdID)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed linking hold %d to withdrawal, %w", holdID, err)
+	}
+
+	err = ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:       userID,
+		Kind:         ledger.WITHDRAWAL,
+		Debit:        ledger.HeldAccount(userID),
+		Credit:       ledger.AccountWithdrawals,
+		Amount:       hold.Sum,
+		OrderID:      hold.Order,
+		WithdrawalID: withdrawalID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed withdrawing held points, %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("balance: failed committing capture transaction, %w", err)
+	}
+	return w, nil
+}
+
+// Returns the held points to the user balance. Releasing the hold again returns
+// it as is, so a retried release succeeds. Captured and expired holds can't be released.
+func (r *repo) ReleaseHold(ctx context.Context, userID string, holdID int64) (*Hold, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed init release transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	hold, err := closeHold(ctx, tx, userID, holdID, HoldReleased)
+	if errors.Is(err, errHoldNotActive) {
+		released, getErr := getHold(ctx, tx, userID, holdID)
+		if getErr == nil && released.Status == HoldReleased {
+			return released, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = releaseHeldPoints(ctx, tx, hold); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("balance: failed committing release transaction, %w", err)
+	}
+	return hold, nil
+}
+
+// Releases active holds which weren't captured in time. Concurrent calls
+// skip each other's holds, so it's safe to run on several instances.
+func (r *repo) ExpireHolds(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("balance: failed init expire holds transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	q := `UPDATE holds SET status = $1, closed_at = NOW()
+	      WHERE id IN (
+	        SELECT id FROM holds
+	        WHERE status = $2 AND expires_at <= NOW()
+	        ORDER BY expires_at LIMIT $3
+	        FOR UPDATE SKIP LOCKED
+	      )
+	      RETURNING ` + holdColumns
+	rows, err := tx.QueryContext(ctx, q, HoldExpired, HoldActive, expireBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("balance: failed expiring holds, %w", err)
+	}
+	expired := []*Hold{}
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scan hold row failed: %w", err)
+		}
+		expired = append(expired, h)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("balance: failed expiring holds, %w", err)
+	}
+
+	for _, h := range expired {
+		if err = releaseHeldPoints(ctx, tx, h); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("balance: failed committing expire holds transaction, %w", err)
+	}
+	return len(expired), nil
+}
+
+// Returns `sum` of the order's withdrawal back to the user, the whole amount
+// left if `sum` is zero. The withdrawal itself is kept, the reversal is linked to it.
+// Fails with `errWithdrawalNotFound` if the order wasn't paid with points
+// and with `errReversalTooBig` if `sum` is more than what's left to reverse.
+func (r *repo) ReverseWithdrawal(ctx context.Context, orderID string, sum money.Amount, reason string) (*Reversal, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed init reversal transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	// The lock serializes concurrent reversals of the same withdrawal
+	var (
+		withdrawalID   int64
+		withdrawn      money.Amount
+		reversedBefore money.Amount
+	)
+	rev := &Reversal{Order: orderID, Reason: reason}
+	q := `SELECT w.id, w.user_id, w.sum,
+	        COALESCE((SELECT SUM(r.sum) FROM withdrawal_reversals r WHERE r.withdrawal_id = w.id), 0)
+	      FROM withdrawals w WHERE w.order_id = $1
+	      FOR UPDATE`
+	err = tx.QueryRowContext(ctx, q, orderID).Scan(&withdrawalID, &rev.UserID, &withdrawn, &reversedBefore)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("balance: order `%s`, %w", orderID, errWithdrawalNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed getting withdrawal of order `%s`, %w", orderID, err)
+	}
+
+	left := withdrawn - reversedBefore
+	if sum == 0 {
+		sum = left
+	}
+	if sum <= 0 || sum > left {
+		return nil, fmt.Errorf("balance: can't reverse %s of `%s`, %s left, %w", sum, orderID, left, errReversalTooBig)
+	}
+	rev.Sum = sum
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO withdrawal_reversals(withdrawal_id, sum, reason) VALUES($1, $2, $3) RETURNING id, created_at`,
+		withdrawalID, sum, reason).Scan(&rev.ID, &rev.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed inserting to `withdrawal_reversals` table, %w", err)
+	}
+
+	err = ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:       rev.UserID,
+		Kind:         ledger.REVERSAL,
+		Debit:        ledger.AccountWithdrawals,
+		Credit:       ledger.UserAccount(rev.UserID),
+		Amount:       sum,
+		OrderID:      orderID,
+		WithdrawalID: withdrawalID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed crediting reversal, %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("balance: failed committing reversal transaction, %w", err)
+	}
+	return rev, nil
+}
+
+// Postings of the user account matching the filter, newest first, with the balance
+// after each of them. The running balance is computed over the whole history,
+// so it's correct on any page and with any filter.
+func (r *repo) GetHistory(ctx context.Context, userID string, f *HistoryFilter) ([]*HistoryEntry, error) {
+	var from, to, afterAt sql.NullTime
+	var afterID int64
+	if !f.From.IsZero() {
+		from = sql.NullTime{Time: f.From, Valid: true}
+	}
+	if !f.To.IsZero() {
+		to = sql.NullTime{Time: f.To, Valid: true}
+	}
+	if f.After != nil {
+		afterAt, afterID = sql.NullTime{Time: f.After.CreatedAt, Valid: true}, f.After.ID
+	}
+	types := f.Types
+	if types == nil {
+		types = []string{}
+	}
+
+	q := `SELECT h.id, h.kind, COALESCE(h.order_id, ''), COALESCE(u.login, ''), h.amount, h.balance, h.created_at
+	      FROM (
+	        SELECT id, kind, order_id, transfer_id, created_at,
+	          CASE WHEN credit_account = $1 THEN amount ELSE -amount END AS amount,
+	          SUM(CASE WHEN credit_account = $1 THEN amount ELSE -amount END)
+	            OVER (ORDER BY created_at, id) AS balance
+	        FROM ledger WHERE debit_account = $1 OR credit_account = $1
+	      ) h
+	      LEFT JOIN transfers t ON t.id = h.transfer_id
+	      LEFT JOIN users u ON u.id = CASE WHEN t.from_user_id = $8 THEN t.to_user_id ELSE t.from_user_id END
+	      WHERE ($2::timestamptz IS NULL OR h.created_at >= $2)
+	        AND ($3::timestamptz IS NULL OR h.created_at < $3)
+	        AND (cardinality($4::text[]) = 0 OR h.kind = ANY($4))
+	        AND ($5::timestamptz IS NULL OR (h.created_at, h.id) < ($5, $6))
+	      ORDER BY h.created_at DESC, h.id DESC
+	      LIMIT $7`
+	rows, err := r.db.QueryContext(ctx, q, ledger.UserAccount(userID), from, to, types, afterAt, afterID, f.Limit, userID)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed getting history of user `%s`, %w", userID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	entries := []*HistoryEntry{}
+	for rows.Next() {
+		e := new(HistoryEntry)
+		if err := rows.Scan(&e.ID, &e.Type, &e.Order, &e.Counterparty, &e.Amount, &e.Balance, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan history row failed: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Moves `sum` from the sender to the user with `toLogin` in one transaction.
+// Fails with `errRecipientNotFound`, `errTransferToSelf`, `errInsufficientFunds`
+// or `errDailyLimitExceeded` if the sender has already sent more than `dailyLimit`
+// during the last 24 hours (zero means no limit).
+func (r *repo) Transfer(ctx context.Context, fromUserID, toLogin string, sum, dailyLimit money.Amount) (*Transfer, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed init transfer transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	t := &Transfer{To: toLogin, Sum: sum}
+	var toUserID string
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE login = $1`, toLogin).Scan(&toUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("balance: user `%s`, %w", toLogin, errRecipientNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed getting recipient `%s`, %w", toLogin, err)
+	}
+	if toUserID == fromUserID {
+		return nil, fmt.Errorf("balance: user `%s`, %w", fromUserID, errTransferToSelf)
+	}
+
+	// Both users are locked in the same order, so opposite transfers don't deadlock
+	_, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, fromUserID, toUserID)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed locking transfer users, %w", err)
+	}
+
+	if dailyLimit > 0 {
+		var sent money.Amount
+		q := `SELECT COALESCE(SUM(sum), 0) FROM transfers
+		      WHERE from_user_id = $1 AND created_at > NOW() - INTERVAL '1 day'`
+		if err = tx.QueryRowContext(ctx, q, fromUserID).Scan(&sent); err != nil {
+			return nil, fmt.Errorf("balance: failed getting transfers of user `%s`, %w", fromUserID, err)
+		}
+		if sent+sum > dailyLimit {
+			return nil, fmt.Errorf("balance: user `%s` sent %s of %s today, %w", fromUserID, sent, dailyLimit, errDailyLimitExceeded)
+		}
+	}
+
+	q := `INSERT INTO transfers(from_user_id, to_user_id, sum) VALUES($1, $2, $3)
+	      RETURNING id, created_at, (SELECT login FROM users WHERE id = $1)`
+	err = tx.QueryRowContext(ctx, q, fromUserID, toUserID, sum).Scan(&t.ID, &t.CreatedAt, &t.From)
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed inserting to `transfers` table, %w", err)
+	}
+
+	err = ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:     fromUserID,
+		Kind:       ledger.TRANSFER,
+		Debit:      ledger.UserAccount(fromUserID),
+		Credit:     ledger.UserAccount(toUserID),
+		Amount:     sum,
+		TransferID: t.ID,
+	})
+	if errors.Is(err, ledger.ErrInsufficientFunds) {
+		return nil, fmt.Errorf("balance: %v, %w", err, errInsufficientFunds)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed transferring points, %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("balance: failed committing transfer transaction, %w", err)
+	}
+	return t, nil
+}
+
+// Transfers sent and received by the user.
+func (r *repo) GetTransfers(ctx context.Context, userID string) ([]*Transfer, error) {
+	q := `SELECT t.id, f.login, tu.login, t.sum, t.created_at
+	      FROM transfers t
+	      JOIN users f ON f.id = t.from_user_id
+	      JOIN users tu ON tu.id = t.to_user_id
+	      WHERE t.from_user_id = $1 OR t.to_user_id = $1
+	      ORDER BY t.created_at DESC`
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	transfers := []*Transfer{}
+	for rows.Next() {
+		t := new(Transfer)
+		if err := rows.Scan(&t.ID, &t.From, &t.To, &t.Sum, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan transfer row failed: %w", err)
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, nil
+}
+
+type iRowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanHold(row iRowScanner) (*Hold, error) {
+	h := new(Hold)
+	err := row.Scan(&h.ID, &h.UserID, &h.Order, &h.Sum, &h.Status, &h.CreatedAt, &h.ExpiresAt)
+	return h, err
+}
+
+func (r *repo) GetHolds(ctx context.Context, userID string) ([]*Hold, error) {
+	q := `SELECT ` + holdColumns + ` FROM holds WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	holds := []*Hold{}
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan hold row failed: %w", err)
+		}
+		holds = append(holds, h)
+	}
+	return holds, nil
+}
+
+// Moves the user's active hold to the final `status`. Fails with `errHoldNotFound`
+// if there is no such hold and with `errHoldNotActive` if it's already closed.
+func closeHold(ctx context.Context, tx *sql.Tx, userID string, holdID int64, status string) (*Hold, error) {
+	q := `UPDATE holds SET status = $1, closed_at = NOW()
+	      WHERE id = $2 AND user_id = $3 AND status = $4 AND ($1 <> $5 OR expires_at > NOW())
+	      RETURNING ` + holdColumns
+	hold, err := scanHold(tx.QueryRowContext(ctx, q, status, holdID, userID, HoldActive, HoldCaptured))
+	if err == nil {
+		return hold, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("balance: failed closing hold %d, %w", holdID, err)
+	}
+
+	current, err := getHold(ctx, tx, userID, holdID)
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("balance: hold %d is %s, %w", holdID, current.Status, errHoldNotActive)
+}
+
+func getHold(ctx context.Context, tx *sql.Tx, userID string, holdID int64) (*Hold, error) {
+	q := `SELECT ` + holdColumns + ` FROM holds WHERE id = $1 AND user_id = $2`
+	hold, err := scanHold(tx.QueryRowContext(ctx, q, holdID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("balance: hold %d, %w", holdID, errHoldNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("balance: failed getting hold %d, %w", holdID, err)
+	}
+	return hold, nil
+}
+
+func releaseHeldPoints(ctx context.Context, tx *sql.Tx, hold *Hold) error {
+	err := ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:  hold.UserID,
+		Kind:    ledger.RELEASE,
+		Debit:   ledger.HeldAccount(hold.UserID),
+		Credit:  ledger.UserAccount(hold.UserID),
+		Amount:  hold.Sum,
+		OrderID: hold.Order,
+	})
+	if err != nil {
+		return fmt.Errorf("balance: failed releasing hold %d, %w", hold.ID, err)
+	}
+	return nil
+}
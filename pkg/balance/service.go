@@ -2,25 +2,67 @@ package balance
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/amiskov/cumulative-loyalty-system/pkg/expiry"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
 )
 
 type iBalanceRepo interface {
-	GetBalance(userID string) (*Balance, error)
-	WithdrawFromUserBalance(userID, orderID string, sum float32) (float32, error)
+	GetBalance(ctx context.Context, userID string) (*Balance, error)
+	WithdrawFromUserBalance(ctx context.Context, userID, orderID string, sum money.Amount) (money.Amount, error)
 	GetWithdrawals(userID string) ([]*Withdraw, error)
+	CreateHold(ctx context.Context, userID, orderID string, sum money.Amount, ttl time.Duration) (*Hold, error)
+	CaptureHold(ctx context.Context, userID string, holdID int64) (*Withdraw, error)
+	ReleaseHold(ctx context.Context, userID string, holdID int64) (*Hold, error)
+	ExpireHolds(ctx context.Context) (int, error)
+	GetHolds(ctx context.Context, userID string) ([]*Hold, error)
+	ReverseWithdrawal(ctx context.Context, orderID string, sum money.Amount, reason string) (*Reversal, error)
+	GetHistory(ctx context.Context, userID string, f *HistoryFilter) ([]*HistoryEntry, error)
+	Transfer(ctx context.Context, fromUserID, toLogin string, sum, dailyLimit money.Amount) (*Transfer, error)
+	GetTransfers(ctx context.Context, userID string) ([]*Transfer, error)
 }
 
+type iExpiringPoints interface {
+	ExpiringSoon(ctx context.Context, userID string) ([]*expiry.Expiring, error)
+}
+
+var (
+	errInsufficientFunds  = errors.New("insufficient funds")
+	errWithdrawalExists   = errors.New("withdrawal for the order already exists")
+	errBadSum             = errors.New("sum must be positive")
+	errHoldExists         = errors.New("order already has an active hold")
+	errHoldNotFound       = errors.New("hold not found")
+	errHoldNotActive      = errors.New("hold is not active")
+	errWithdrawalNotFound = errors.New("withdrawal not found")
+	errReversalTooBig     = errors.New("reversal exceeds the withdrawal")
+	errBadCursor          = errors.New("bad cursor")
+	errBadHistoryFilter   = errors.New("bad history filter")
+	errRecipientNotFound  = errors.New("recipient not found")
+	errTransferToSelf     = errors.New("can't transfer to yourself")
+	errTransferTooSmall   = errors.New("transfer is too small")
+	errDailyLimitExceeded = errors.New("daily transfer limit exceeded")
+)
+
 type service struct {
-	repo iBalanceRepo
+	repo           iBalanceRepo
+	expiring       iExpiringPoints
+	holdTTL        time.Duration // holds not captured during this time are released
+	transferLimits TransferLimits
 }
 
-func NewService(r iBalanceRepo) *service {
+func NewService(r iBalanceRepo, expiring iExpiringPoints, holdTTL time.Duration,
+	transferLimits TransferLimits) *service {
 	return &service{
-		repo: r,
+		repo:           r,
+		expiring:       expiring,
+		holdTTL:        holdTTL,
+		transferLimits: transferLimits,
 	}
 }
 
@@ -40,29 +82,22 @@ func (s *service) Withdrawals(ctx context.Context) ([]*Withdraw, error) {
 	return withdrawals, nil
 }
 
-func (s *service) Withdraw(ctx context.Context, w *Withdraw) (float32, error) {
+func (s *service) Withdraw(ctx context.Context, w *Withdraw) (money.Amount, error) {
 	userID, err := session.GetAuthUserID(ctx)
 	if err != nil {
 		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
 		return 0, err
 	}
 
-	bal, err := s.repo.GetBalance(userID)
-	if err != nil {
-		logger.Log(ctx).Errorf("balance: can't get user balance, %v", err)
-		return 0, err
+	if w.Sum <= 0 {
+		return 0, fmt.Errorf("balance: can't withdraw `%s`, %w", w.Sum, errBadSum)
 	}
 
-	if w.Sum > bal.Current {
-		msg := fmt.Sprintf("balance: can't withdraw sum `%f` from balance `%f`", w.Sum, bal.Current)
-		logger.Log(ctx).Error(msg)
-		return bal.Current, fmt.Errorf(msg)
-	}
-
-	newBalance, err := s.repo.WithdrawFromUserBalance(userID, w.Order, w.Sum)
+	// The balance is checked by the DB atomically with the debit
+	newBalance, err := s.repo.WithdrawFromUserBalance(ctx, userID, w.Order, w.Sum)
 	if err != nil {
 		logger.Log(ctx).Errorf("balance: withdraw failed, %v", err)
-		return bal.Current, err
+		return 0, err
 	}
 
 	return newBalance, nil
@@ -75,10 +110,213 @@ func (s *service) GetUserBalance(ctx context.Context) (*Balance, error) {
 		return nil, err
 	}
 
-	bal, err := s.repo.GetBalance(userID)
+	bal, err := s.repo.GetBalance(ctx, userID)
 	if err != nil {
 		logger.Log(ctx).Errorf("balance: can't get user balance, %v", err)
 		return nil, err
 	}
+
+	bal.ExpiringSoon, err = s.expiring.ExpiringSoon(ctx, userID)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get expiring points, %v", err)
+		return nil, err
+	}
 	return bal, nil
 }
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// A page of the authorized user balance history.
+func (s *service) History(ctx context.Context, f *HistoryFilter) (*HistoryPage, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	for _, t := range f.Types {
+		if !ledger.IsKind(t) {
+			return nil, fmt.Errorf("balance: unknown entry type `%s`, %w", t, errBadHistoryFilter)
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return nil, fmt.Errorf("balance: `from` must be before `to`, %w", errBadHistoryFilter)
+	}
+	switch {
+	case f.Limit == 0:
+		f.Limit = defaultHistoryLimit
+	case f.Limit < 0 || f.Limit > maxHistoryLimit:
+		return nil, fmt.Errorf("balance: limit must be 1..%d, %w", maxHistoryLimit, errBadHistoryFilter)
+	}
+
+	// One more entry tells if there is a next page
+	limit := f.Limit
+	f.Limit++
+	entries, err := s.repo.GetHistory(ctx, userID, f)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get user history, %v", err)
+		return nil, err
+	}
+
+	page := &HistoryPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		last := page.Entries[limit-1]
+		page.NextCursor = (&HistoryCursor{CreatedAt: last.CreatedAt, ID: last.ID}).encode()
+	}
+	return page, nil
+}
+
+// Sends points of the authorized user to the user with the `To` login.
+func (s *service) Transfer(ctx context.Context, t *Transfer) (*Transfer, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	if t.Sum <= 0 {
+		return nil, fmt.Errorf("balance: can't transfer `%s`, %w", t.Sum, errBadSum)
+	}
+	if t.Sum < s.transferLimits.Min {
+		return nil, fmt.Errorf("balance: can't transfer `%s`, min is %s, %w", t.Sum, s.transferLimits.Min, errTransferTooSmall)
+	}
+
+	sent, err := s.repo.Transfer(ctx, userID, t.To, t.Sum, s.transferLimits.Daily)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: transfer failed, %v", err)
+		return nil, err
+	}
+	return sent, nil
+}
+
+func (s *service) Transfers(ctx context.Context) ([]*Transfer, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	transfers, err := s.repo.GetTransfers(ctx, userID)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get user transfers, %v", err)
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// Returns points of the order's withdrawal to the user. Called by support,
+// so there is no authorized user.
+func (s *service) ReverseWithdrawal(ctx context.Context, rev *Reversal) (*Reversal, error) {
+	if rev.Sum < 0 {
+		return nil, fmt.Errorf("balance: can't reverse `%s`, %w", rev.Sum, errBadSum)
+	}
+
+	reversed, err := s.repo.ReverseWithdrawal(ctx, rev.Order, rev.Sum, rev.Reason)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: reversal failed, %v", err)
+		return nil, err
+	}
+	logger.Log(ctx).Infof("balance: reversed %s of `%s` withdrawal for user `%s`",
+		reversed.Sum, reversed.Order, reversed.UserID)
+	return reversed, nil
+}
+
+// Reserves points of the authorized user for the order.
+func (s *service) CreateHold(ctx context.Context, h *Hold) (*Hold, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	if h.Sum <= 0 {
+		return nil, fmt.Errorf("balance: can't hold `%s`, %w", h.Sum, errBadSum)
+	}
+
+	hold, err := s.repo.CreateHold(ctx, userID, h.Order, h.Sum, s.holdTTL)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: hold failed, %v", err)
+		return nil, err
+	}
+	return hold, nil
+}
+
+func (s *service) CaptureHold(ctx context.Context, holdID int64) (*Withdraw, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	w, err := s.repo.CaptureHold(ctx, userID, holdID)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: capture failed, %v", err)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *service) ReleaseHold(ctx context.Context, holdID int64) (*Hold, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	hold, err := s.repo.ReleaseHold(ctx, userID, holdID)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: release failed, %v", err)
+		return nil, err
+	}
+	return hold, nil
+}
+
+func (s *service) Holds(ctx context.Context) ([]*Hold, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	holds, err := s.repo.GetHolds(ctx, userID)
+	if err != nil {
+		logger.Log(ctx).Errorf("balance: can't get user holds, %v", err)
+		return nil, err
+	}
+	return holds, nil
+}
+
+// Releases expired holds every `interval` until `ctx` is done.
+func (s *service) RunHoldExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireHolds(ctx)
+		}
+	}
+}
+
+func (s *service) expireHolds(ctx context.Context) {
+	for {
+		expired, err := s.repo.ExpireHolds(ctx)
+		if err != nil {
+			logger.Log(ctx).Error(err)
+			return
+		}
+		if expired > 0 {
+			logger.Log(ctx).Infof("balance: released %d expired holds", expired)
+		}
+		if expired < expireBatchSize {
+			return
+		}
+	}
+}
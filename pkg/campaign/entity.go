@@ -0,0 +1,84 @@
+package campaign
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// A campaign gives extra points for PROCESSED orders uploaded during its window,
+// e.g. "double points this weekend" or "+100 points on your first order".
+// An order gets bonuses of all the campaigns it's eligible for.
+//
+// The window is checked against the time the order was uploaded, not processed:
+// uploading is the closest we get to the purchase time. So an order uploaded
+// before the start gets nothing even if it's processed during the campaign, and
+// an order uploaded on the last day gets the bonus whenever it's processed,
+// with the campaign rules as they are at that moment.
+type Campaign struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	StartsAt time.Time `json:"starts_at"` // orders uploaded from this moment
+	EndsAt   time.Time `json:"ends_at"`   // exclusive
+
+	// Eligibility rules, empty ones match every order
+	FirstOrder  bool   `json:"first_order,omitempty"`  // only the earliest uploaded order of the user which isn't INVALID
+	Tier        string `json:"tier,omitempty"`         // only users of the tier
+	OrderPrefix string `json:"order_prefix,omitempty"` // only order numbers starting with it
+
+	// The bonus is `fixed + accrual * (multiplier - 1)`
+	Multiplier money.Amount `json:"multiplier"`
+	Fixed      money.Amount `json:"fixed"`
+
+	Credited  money.Amount `json:"credited"` // bonuses credited so far
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// The multiplier which doesn't add anything.
+const noMultiplier money.Amount = 100 // 1.00
+
+var ErrInvalid = errors.New("invalid campaign")
+
+// Describes which field of the campaign is invalid, matches `ErrInvalid` with `errors.Is`.
+type ValidationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s %s", ErrInvalid, e.Field, e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// Extra points the campaign gives for the order accrual.
+func (c *Campaign) Bonus(accrual money.Amount) money.Amount {
+	return c.Fixed + accrual.Mul(c.Multiplier) - accrual
+}
+
+// What the order and its user look like for eligibility rules.
+type Eligibility struct {
+	UploadedAt time.Time
+	FirstOrder bool
+	Tier       string
+}
+
+// Reports whether the order gets the campaign bonus.
+func (c *Campaign) Matches(orderID string, e *Eligibility) bool {
+	switch {
+	case e.UploadedAt.Before(c.StartsAt) || !e.UploadedAt.Before(c.EndsAt):
+		return false
+	case c.FirstOrder && !e.FirstOrder:
+		return false
+	case c.Tier != "" && c.Tier != e.Tier:
+		return false
+	case !strings.HasPrefix(orderID, c.OrderPrefix):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+)
+
+type iService interface {
+	Create(ctx context.Context, c *Campaign) (*Campaign, error)
+	Campaigns(ctx context.Context) ([]*Campaign, error)
+	Campaign(ctx context.Context, id int64) (*Campaign, error)
+	Update(ctx context.Context, c *Campaign) (*Campaign, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type handler struct {
+	service iService
+}
+
+func NewHandler(s iService) *handler {
+	return &handler{
+		service: s,
+	}
+}
+
+func (h *handler) Create(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c := new(Campaign)
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		logger.Log(r.Context()).Errorf("can't parse request body as campaign: %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.service.Create(r.Context(), c)
+	if err != nil {
+		writeError(w, err, "failed to create campaign")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	common.WriteRespJSON(w, created)
+}
+
+func (h *handler) Campaigns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	campaigns, err := h.service.Campaigns(r.Context())
+	if err != nil {
+		common.WriteMsg(w, "can't get campaigns", http.StatusInternalServerError)
+		return
+	}
+	common.WriteRespJSON(w, campaigns)
+}
+
+func (h *handler) Campaign(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := h.service.Campaign(r.Context(), id)
+	if err != nil {
+		writeError(w, err, "can't get campaign")
+		return
+	}
+	common.WriteRespJSON(w, c)
+}
+
+// Replaces the whole campaign.
+func (h *handler) Update(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+
+	c := new(Campaign)
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		logger.Log(r.Context()).Errorf("can't parse request body as campaign: %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+	c.ID = id
+
+	updated, err := h.service.Update(r.Context(), c)
+	if err != nil {
+		writeError(w, err, "failed to update campaign")
+		return
+	}
+	common.WriteRespJSON(w, updated)
+}
+
+func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), id); err != nil {
+		writeError(w, err, "failed to delete campaign")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseCampaignID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		common.WriteMsg(w, "bad campaign id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeError(w http.ResponseWriter, err error, fallback string) {
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		common.WriteValidationMsg(w, "campaign is not valid", validationErr.Field, validationErr.Reason)
+	case errors.Is(err, errNotFound):
+		common.WriteMsg(w, "campaign not found", http.StatusNotFound)
+	case errors.Is(err, errHasCredits):
+		common.WriteMsg(w, "campaign has credited bonuses, end it instead", http.StatusConflict)
+	default:
+		common.WriteMsg(w, fallback, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,183 @@
+package campaign
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+const campaignColumns = `c.id, c.name, c.starts_at, c.ends_at, c.first_order, c.tier, c.order_prefix,
+	c.multiplier, c.fixed, c.created_at,
+	COALESCE((SELECT SUM(l.amount) FROM ledger l WHERE l.campaign_id = c.id), 0)`
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCampaign(row scanner) (*Campaign, error) {
+	c := new(Campaign)
+	err := row.Scan(&c.ID, &c.Name, &c.StartsAt, &c.EndsAt, &c.FirstOrder, &c.Tier, &c.OrderPrefix,
+		&c.Multiplier, &c.Fixed, &c.CreatedAt, &c.Credited)
+	return c, err
+}
+
+func (r *repo) Create(ctx context.Context, c *Campaign) (*Campaign, error) {
+	q := `INSERT INTO campaigns(name, starts_at, ends_at, first_order, tier, order_prefix, multiplier, fixed)
+	      VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+	      RETURNING id, created_at`
+	created := *c
+	created.Credited = 0
+	err := r.db.QueryRowContext(ctx, q, c.Name, c.StartsAt, c.EndsAt, c.FirstOrder, c.Tier, c.OrderPrefix,
+		c.Multiplier, c.Fixed).Scan(&created.ID, &created.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("campaign/repo: failed creating campaign, %w", err)
+	}
+	return &created, nil
+}
+
+func (r *repo) GetAll(ctx context.Context) ([]*Campaign, error) {
+	q := `SELECT ` + campaignColumns + ` FROM campaigns c ORDER BY c.starts_at DESC, c.id DESC`
+	rows, err := r.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("campaign/repo: failed getting campaigns, %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	campaigns := []*Campaign{}
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan campaign row failed: %w", err)
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, nil
+}
+
+func (r *repo) Get(ctx context.Context, id int64) (*Campaign, error) {
+	q := `SELECT ` + campaignColumns + ` FROM campaigns c WHERE c.id = $1`
+	c, err := scanCampaign(r.db.QueryRowContext(ctx, q, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("campaign/repo: campaign `%d`, %w", id, errNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("campaign/repo: failed getting campaign `%d`, %w", id, err)
+	}
+	return c, nil
+}
+
+// Changes apply to orders processed from now on, bonuses already credited stay.
+func (r *repo) Update(ctx context.Context, c *Campaign) (*Campaign, error) {
+	q := `UPDATE campaigns SET name = $2, starts_at = $3, ends_at = $4, first_order = $5, tier = $6,
+	        order_prefix = $7, multiplier = $8, fixed = $9
+	      WHERE id = $1`
+	res, err := r.db.ExecContext(ctx, q, c.ID, c.Name, c.StartsAt, c.EndsAt, c.FirstOrder, c.Tier,
+		c.OrderPrefix, c.Multiplier, c.Fixed)
+	if err != nil {
+		return nil, fmt.Errorf("campaign/repo: failed updating campaign `%d`, %w", c.ID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, fmt.Errorf("campaign/repo: campaign `%d`, %w", c.ID, errNotFound)
+	}
+	return r.Get(ctx, c.ID)
+}
+
+// Campaigns which have credited bonuses can't be deleted, they are referenced
+// by the ledger. Such campaigns should be ended instead.
+func (r *repo) Delete(ctx context.Context, id int64) error {
+	q := `DELETE FROM campaigns c WHERE c.id = $1
+	      AND NOT EXISTS (SELECT 1 FROM ledger l WHERE l.campaign_id = c.id)`
+	res, err := r.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return fmt.Errorf("campaign/repo: failed deleting campaign `%d`, %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+
+	if _, err := r.Get(ctx, id); err != nil {
+		return err
+	}
+	return fmt.Errorf("campaign/repo: campaign `%d`, %w", id, errHasCredits)
+}
+
+// Campaigns whose window covers `at`.
+func (r *repo) GetActive(ctx context.Context, tx *sql.Tx, at time.Time) ([]*Campaign, error) {
+	q := `SELECT ` + campaignColumns + ` FROM campaigns c
+	      WHERE c.starts_at <= $1 AND c.ends_at > $1 ORDER BY c.id`
+	rows, err := tx.QueryContext(ctx, q, at)
+	if err != nil {
+		return nil, fmt.Errorf("campaign/repo: failed getting active campaigns, %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	campaigns := []*Campaign{}
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan campaign row failed: %w", err)
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, nil
+}
+
+// The tier is the last one recorded for the user, `tier` hooks record it first.
+// The order is the first one if the user has no earlier orders except INVALID ones,
+// cancelled orders count, so cancelling the first order doesn't make another one first.
+// Locks the user row, so concurrently processed orders of the user are checked one by one.
+func (r *repo) GetEligibility(ctx context.Context, tx *sql.Tx, userID, orderID string) (*Eligibility, error) {
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, fmt.Errorf("campaign/repo: failed locking user `%s`, %w", userID, err)
+	}
+
+	q := `SELECT o.uploaded_at,
+	        NOT EXISTS (SELECT 1 FROM orders p WHERE p.user_id = o.user_id AND p.id <> o.id AND p.status <> 'INVALID'
+	                    AND (p.uploaded_at, p.id) < (o.uploaded_at, o.id)),
+	        COALESCE((SELECT t.tier FROM user_tiers t WHERE t.user_id = o.user_id
+	                  ORDER BY t.created_at DESC, t.id DESC LIMIT 1), '')
+	      FROM orders o WHERE o.id = $1 AND o.user_id = $2`
+	e := new(Eligibility)
+	err := tx.QueryRowContext(ctx, q, orderID, userID).Scan(&e.UploadedAt, &e.FirstOrder, &e.Tier)
+	if err != nil {
+		return nil, fmt.Errorf("campaign/repo: failed getting eligibility of `%s`, %w", orderID, err)
+	}
+	return e, nil
+}
+
+func (r *repo) CreditBonus(ctx context.Context, tx *sql.Tx, campaignID int64, userID, orderID string, bonus money.Amount) error {
+	err := ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:     userID,
+		Kind:       ledger.BONUS,
+		Debit:      ledger.AccountCampaigns,
+		Credit:     ledger.UserAccount(userID),
+		Amount:     bonus,
+		OrderID:    orderID,
+		CampaignID: campaignID,
+	})
+	if err != nil {
+		return fmt.Errorf("campaign/repo: failed crediting campaign `%d` bonus for `%s`, %w", campaignID, orderID, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package campaign
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/order"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/tier"
+)
+
+type iCampaignRepo interface {
+	Create(ctx context.Context, c *Campaign) (*Campaign, error)
+	GetAll(ctx context.Context) ([]*Campaign, error)
+	Get(ctx context.Context, id int64) (*Campaign, error)
+	Update(ctx context.Context, c *Campaign) (*Campaign, error)
+	Delete(ctx context.Context, id int64) error
+	GetActive(ctx context.Context, tx *sql.Tx, at time.Time) ([]*Campaign, error)
+	GetEligibility(ctx context.Context, tx *sql.Tx, userID, orderID string) (*Eligibility, error)
+	CreditBonus(ctx context.Context, tx *sql.Tx, campaignID int64, userID, orderID string, bonus money.Amount) error
+}
+
+var (
+	errNotFound   = errors.New("campaign not found")
+	errHasCredits = errors.New("campaign has credited bonuses")
+)
+
+// Campaign names are stored in VARCHAR(128) columns.
+const maxNameLength = 128
+
+type service struct {
+	repo  iCampaignRepo
+	tiers tier.Tiers
+}
+
+func NewService(r iCampaignRepo, tiers tier.Tiers) *service {
+	return &service{
+		repo:  r,
+		tiers: tiers,
+	}
+}
+
+func (s *service) Create(ctx context.Context, c *Campaign) (*Campaign, error) {
+	if err := s.validate(c); err != nil {
+		return nil, err
+	}
+	created, err := s.repo.Create(ctx, c)
+	if err != nil {
+		logger.Log(ctx).Errorf("campaign: can't create campaign, %v", err)
+		return nil, err
+	}
+	logger.Log(ctx).Infof("campaign: created `%s` (%d)", created.Name, created.ID)
+	return created, nil
+}
+
+func (s *service) Campaigns(ctx context.Context) ([]*Campaign, error) {
+	campaigns, err := s.repo.GetAll(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("campaign: can't get campaigns, %v", err)
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+func (s *service) Campaign(ctx context.Context, id int64) (*Campaign, error) {
+	c, err := s.repo.Get(ctx, id)
+	if err != nil && !errors.Is(err, errNotFound) {
+		logger.Log(ctx).Errorf("campaign: can't get campaign, %v", err)
+	}
+	return c, err
+}
+
+func (s *service) Update(ctx context.Context, c *Campaign) (*Campaign, error) {
+	if err := s.validate(c); err != nil {
+		return nil, err
+	}
+	updated, err := s.repo.Update(ctx, c)
+	if err != nil {
+		if !errors.Is(err, errNotFound) {
+			logger.Log(ctx).Errorf("campaign: can't update campaign, %v", err)
+		}
+		return nil, err
+	}
+	logger.Log(ctx).Infof("campaign: updated `%s` (%d)", updated.Name, updated.ID)
+	return updated, nil
+}
+
+func (s *service) Delete(ctx context.Context, id int64) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if !errors.Is(err, errNotFound) && !errors.Is(err, errHasCredits) {
+			logger.Log(ctx).Errorf("campaign: can't delete campaign, %v", err)
+		}
+		return err
+	}
+	logger.Log(ctx).Infof("campaign: deleted %d", id)
+	return nil
+}
+
+// Processed order hook: credits bonuses of all the campaigns the order is eligible for,
+// each one as a separate posting linked to the campaign.
+func (s *service) CreditBonuses(ctx context.Context, tx *sql.Tx, o *order.Order) error {
+	eligibility, err := s.repo.GetEligibility(ctx, tx, o.UserID, o.Number)
+	if err != nil {
+		return err
+	}
+
+	campaigns, err := s.repo.GetActive(ctx, tx, eligibility.UploadedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range campaigns {
+		if !c.Matches(o.Number, eligibility) {
+			continue
+		}
+		bonus := c.Bonus(o.Accrual)
+		if bonus <= 0 {
+			continue
+		}
+		if err := s.repo.CreditBonus(ctx, tx, c.ID, o.UserID, o.Number, bonus); err != nil {
+			return err
+		}
+		logger.Log(ctx).Infof("campaign: `%s` bonus %s for `%s`", c.Name, bonus, o.Number)
+	}
+	return nil
+}
+
+// Normalizes the campaign and checks it gives something to somebody.
+func (s *service) validate(c *Campaign) error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Tier = strings.ToUpper(strings.TrimSpace(c.Tier))
+	if c.Multiplier == 0 {
+		c.Multiplier = noMultiplier
+	}
+
+	switch {
+	case c.Name == "":
+		return &ValidationError{Field: "name", Reason: "empty"}
+	case len(c.Name) > maxNameLength:
+		return &ValidationError{Field: "name", Reason: "too long"}
+	case c.StartsAt.IsZero():
+		return &ValidationError{Field: "starts_at", Reason: "empty"}
+	case !c.EndsAt.After(c.StartsAt):
+		return &ValidationError{Field: "ends_at", Reason: "must be after starts_at"}
+	case c.Tier != "" && !s.tiers.Has(c.Tier):
+		return &ValidationError{Field: "tier", Reason: "unknown tier"}
+	case strings.Trim(c.OrderPrefix, "0123456789") != "":
+		return &ValidationError{Field: "order_prefix", Reason: "not digits"}
+	case c.Multiplier < noMultiplier:
+		return &ValidationError{Field: "multiplier", Reason: "must be at least 1"}
+	case c.Fixed < 0:
+		return &ValidationError{Field: "fixed", Reason: "can't be negative"}
+	case c.Multiplier == noMultiplier && c.Fixed == 0:
+		return &ValidationError{Field: "multiplier", Reason: "campaign gives no bonus"}
+	}
+	return nil
+}
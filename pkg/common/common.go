@@ -2,23 +2,39 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/crypto/argon2"
 )
 
+const pgUniqueViolation = "23505"
+
 type Msg struct {
 	Message string `json:"message"`
 }
 
+type ValidationMsg struct {
+	Message string `json:"message"`
+	Field   string `json:"field"`
+	Reason  string `json:"reason"`
+}
+
 func WriteMsg(w http.ResponseWriter, msg string, code int) {
 	w.WriteHeader(code)
 	WriteRespJSON(w, Msg{msg})
 }
 
+// Writes `422 Unprocessable Entity` telling which field is invalid and why.
+func WriteValidationMsg(w http.ResponseWriter, msg, field, reason string) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	WriteRespJSON(w, ValidationMsg{Message: msg, Field: field, Reason: reason})
+}
+
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func RandStringRunes(n int) string {
@@ -56,3 +72,15 @@ func WriteRespJSON(w http.ResponseWriter, data interface{}) {
 		log.Println("common: failed writing response", err)
 	}
 }
+
+// Reports whether the DB error is a violation of a UNIQUE constraint.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// Reports whether the DB error is a violation of the UNIQUE constraint or index `name`.
+func IsUniqueViolationOf(err error, name string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == name
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
 )
 
 type Config struct {
@@ -13,8 +15,33 @@ type Config struct {
 	DatabaseURI            string
 	AccrualSystemAddress   string        // full address, like `http//localhost:8888`
 	AccrualPollingLimit    int           // max attempts to get order info from accrual system
-	AccrualPollingInterval time.Duration // pause between attempts to get an order info from accrual
+	AccrualPollingInterval time.Duration // base pause between attempts to get an order info from accrual
 	AccrualRequestTimeout  time.Duration
+	AccrualWorkers         int           // number of workers polling the accrual system concurrently
+	AccrualQueueSize       int           // max number of orders waiting for a free worker
+	AccrualBackoffFactor   float64       // the pause between attempts grows by this factor
+	AccrualBackoffMaxDelay time.Duration // max pause between attempts
+	AccrualBackoffJitter   float64       // random spread of the pause, fraction of it (0..1)
+	AccrualMaxElapsed      time.Duration // give up polling an order after this time
+	AccrualBreakerFailures int           // consecutive failures which open the accrual circuit breaker
+	AccrualBreakerCooldown time.Duration // how long the circuit stays open
+	AccrualBreakerProbes   int           // calls allowed in the half-open state
+	AccrualWebhookSecret   string        // HMAC secret for accrual updates pushed to the webhook, empty disables it
+	ReconcileInterval      time.Duration // how often to reconcile orders with accrual, 0 disables it
+	ReconcileWindow        time.Duration // reconcile orders uploaded during this period
+	ReconcileApply         bool          // correct mismatches found by scheduled reconciliation
+	IdempotencyTTL         time.Duration // how long responses to requests with `Idempotency-Key` are kept
+	HoldTTL                time.Duration // holds not captured during this time are released
+	AdminToken             string        // bearer token of the support API, empty disables it
+	PartnerSecret          string        // HMAC secret for signed partner requests, empty disables them
+	ClawbackPolicy         string        // what to do when a cancelled order accrual is spent: negative, cap or block
+	PointsExpireMonths     int           // points expire this many months after they were earned, 0 disables it
+	PointsExpiringSoon     time.Duration // points expiring during this period are reported in the balance
+	TransferMin            money.Amount  // the smallest transfer between users
+	TransferDailyLimit     money.Amount  // max sum a user can send during 24 hours, 0 means no limit
+	Tiers                  string        // loyalty tiers as `name:threshold:multiplier,...`
+	ReferralReward         money.Amount  // credited to the referrer and the referee each, 0 disables rewards
+	ReferralIPHeader       string        // trusted client address header, enables the self-referral check by address
 	LogLevel               string
 	SecretKey              string
 }
@@ -27,11 +54,29 @@ func Parse() *Config {
 		AccrualPollingLimit:    100,
 		AccrualPollingInterval: 1 * time.Second,
 		AccrualRequestTimeout:  3 * time.Second,
+		AccrualWorkers:         4,
+		AccrualQueueSize:       100,
+		AccrualBackoffFactor:   2,
+		AccrualBackoffMaxDelay: 5 * time.Minute,
+		AccrualBackoffJitter:   0.2,
+		AccrualMaxElapsed:      72 * time.Hour,
+		AccrualBreakerFailures: 5,
+		AccrualBreakerCooldown: 30 * time.Second,
+		AccrualBreakerProbes:   1,
+		ReconcileWindow:        7 * 24 * time.Hour,
+		IdempotencyTTL:         24 * time.Hour,
+		HoldTTL:                15 * time.Minute,
+		ClawbackPolicy:         "block",
+		PointsExpiringSoon:     30 * 24 * time.Hour,
+		TransferMin:            100,    // 1.00
+		TransferDailyLimit:     100000, // 1000.00
+		Tiers:                  "BRONZE:0:1",
 		SecretKey:              "secret",
 		LogLevel:               "debug",
 	}
 	cfg.updateFromFlags()
 	cfg.updateFromEnv()
+	cfg.validate()
 	return &cfg
 }
 
@@ -45,6 +90,53 @@ func (cfg *Config) updateFromFlags() {
 		"Max attempts to get order accrual from the accrual system.")
 	flagAccrualRequestTimeout := flag.Duration("t", cfg.AccrualPollingInterval,
 		"Pause between attempts to get order accrual.")
+	flagAccrualWorkers := flag.Int("w", cfg.AccrualWorkers, "Number of workers polling the accrual system.")
+	flagAccrualQueueSize := flag.Int("q", cfg.AccrualQueueSize, "Max number of orders waiting for an accrual worker.")
+	flagAccrualBackoffFactor := flag.Float64("backoff-factor", cfg.AccrualBackoffFactor,
+		"Factor the pause between accrual attempts grows by.")
+	flagAccrualBackoffMaxDelay := flag.Duration("backoff-max-delay", cfg.AccrualBackoffMaxDelay,
+		"Max pause between accrual attempts.")
+	flagAccrualBackoffJitter := flag.Float64("backoff-jitter", cfg.AccrualBackoffJitter,
+		"Random spread of the pause between accrual attempts, fraction of the pause (0..1).")
+	flagAccrualMaxElapsed := flag.Duration("accrual-max-elapsed", cfg.AccrualMaxElapsed,
+		"Give up polling an order accrual after this time.")
+	flagAccrualBreakerFailures := flag.Int("breaker-failures", cfg.AccrualBreakerFailures,
+		"Consecutive accrual failures which open the circuit breaker.")
+	flagAccrualBreakerCooldown := flag.Duration("breaker-cooldown", cfg.AccrualBreakerCooldown,
+		"How long the accrual circuit breaker stays open.")
+	flagAccrualBreakerProbes := flag.Int("breaker-probes", cfg.AccrualBreakerProbes,
+		"Accrual calls allowed while the circuit breaker is half-open.")
+	flagAccrualWebhookSecret := flag.String("webhook-secret", cfg.AccrualWebhookSecret,
+		"Secret for signed accrual updates pushed to the webhook, empty disables it.")
+	flagReconcileInterval := flag.Duration("reconcile-interval", cfg.ReconcileInterval,
+		"How often to reconcile orders with the accrual system, 0 disables it.")
+	flagReconcileWindow := flag.Duration("reconcile-window", cfg.ReconcileWindow,
+		"Reconcile orders uploaded during this period.")
+	flagReconcileApply := flag.Bool("reconcile-apply", cfg.ReconcileApply,
+		"Correct mismatches found by scheduled reconciliation.")
+	flagIdempotencyTTL := flag.Duration("idempotency-ttl", cfg.IdempotencyTTL,
+		"How long responses to requests with Idempotency-Key are kept.")
+	flagHoldTTL := flag.Duration("hold-ttl", cfg.HoldTTL, "Holds not captured during this time are released.")
+	flagAdminToken := flag.String("admin-token", cfg.AdminToken, "Bearer token of the support API, empty disables it.")
+	flagPartnerSecret := flag.String("partner-secret", cfg.PartnerSecret,
+		"Secret for signed partner requests, empty disables them.")
+	flagClawbackPolicy := flag.String("clawback-policy", cfg.ClawbackPolicy,
+		"What to do when the accrual of a cancelled order is already spent: negative, cap or block.")
+	flagPointsExpireMonths := flag.Int("points-expire-months", cfg.PointsExpireMonths,
+		"Points expire this many months after they were earned, 0 disables expiration.")
+	flagPointsExpiringSoon := flag.Duration("points-expiring-soon", cfg.PointsExpiringSoon,
+		"Points expiring during this period are reported in the balance.")
+	flagTransferMin := flag.String("transfer-min", cfg.TransferMin.String(), "The smallest transfer between users.")
+	flagTransferDailyLimit := flag.String("transfer-daily-limit", cfg.TransferDailyLimit.String(),
+		"Max sum a user can send during 24 hours, 0 means no limit.")
+	flagTiers := flag.String("tiers", cfg.Tiers,
+		"Loyalty tiers as name:threshold:multiplier separated by commas, thresholds are points accrued in 12 months, "+
+			"e.g. BRONZE:0:1,SILVER:1000:1.1,GOLD:5000:1.25.")
+	flagReferralReward := flag.String("referral-reward", cfg.ReferralReward.String(),
+		"Points credited to the referrer and the new user each on the first PROCESSED order, 0 disables rewards.")
+	flagReferralIPHeader := flag.String("referral-ip-header", cfg.ReferralIPHeader,
+		"Trusted header with the client address set by the proxy, e.g. X-Real-IP. "+
+			"Referrals from the referrer's address are rejected. Empty disables the check.")
 
 	flag.Parse()
 
@@ -54,6 +146,31 @@ func (cfg *Config) updateFromFlags() {
 	cfg.AccrualPollingLimit = *flagAccrualPollingLimit
 	cfg.AccrualPollingInterval = *flagAccrualPollingInterval
 	cfg.AccrualRequestTimeout = *flagAccrualRequestTimeout
+	cfg.AccrualWorkers = *flagAccrualWorkers
+	cfg.AccrualQueueSize = *flagAccrualQueueSize
+	cfg.AccrualBackoffFactor = *flagAccrualBackoffFactor
+	cfg.AccrualBackoffMaxDelay = *flagAccrualBackoffMaxDelay
+	cfg.AccrualBackoffJitter = *flagAccrualBackoffJitter
+	cfg.AccrualMaxElapsed = *flagAccrualMaxElapsed
+	cfg.AccrualBreakerFailures = *flagAccrualBreakerFailures
+	cfg.AccrualBreakerCooldown = *flagAccrualBreakerCooldown
+	cfg.AccrualBreakerProbes = *flagAccrualBreakerProbes
+	cfg.AccrualWebhookSecret = *flagAccrualWebhookSecret
+	cfg.ReconcileInterval = *flagReconcileInterval
+	cfg.ReconcileWindow = *flagReconcileWindow
+	cfg.ReconcileApply = *flagReconcileApply
+	cfg.IdempotencyTTL = *flagIdempotencyTTL
+	cfg.HoldTTL = *flagHoldTTL
+	cfg.AdminToken = *flagAdminToken
+	cfg.PartnerSecret = *flagPartnerSecret
+	cfg.ClawbackPolicy = *flagClawbackPolicy
+	cfg.PointsExpireMonths = *flagPointsExpireMonths
+	cfg.PointsExpiringSoon = *flagPointsExpiringSoon
+	cfg.TransferMin = parseAmount(*flagTransferMin, "transfer min")
+	cfg.TransferDailyLimit = parseAmount(*flagTransferDailyLimit, "transfer daily limit")
+	cfg.Tiers = *flagTiers
+	cfg.ReferralReward = parseAmount(*flagReferralReward, "referral reward")
+	cfg.ReferralIPHeader = *flagReferralIPHeader
 }
 
 func (cfg *Config) updateFromEnv() {
@@ -87,6 +204,145 @@ func (cfg *Config) updateFromEnv() {
 		}
 		cfg.AccrualRequestTimeout = time.Duration(t) * time.Second
 	}
+	if workers, ok := os.LookupEnv("ACCRUAL_WORKERS"); ok {
+		w, err := strconv.Atoi(workers)
+		if err != nil {
+			log.Fatal("bad accrual workers value, must be int")
+		}
+		cfg.AccrualWorkers = w
+	}
+	if size, ok := os.LookupEnv("ACCRUAL_QUEUE_SIZE"); ok {
+		q, err := strconv.Atoi(size)
+		if err != nil {
+			log.Fatal("bad accrual queue size value, must be int")
+		}
+		cfg.AccrualQueueSize = q
+	}
+	if factor, ok := os.LookupEnv("ACCRUAL_BACKOFF_FACTOR"); ok {
+		f, err := strconv.ParseFloat(factor, 64)
+		if err != nil {
+			log.Fatal("bad accrual backoff factor value, must be float")
+		}
+		cfg.AccrualBackoffFactor = f
+	}
+	if maxDelay, ok := os.LookupEnv("ACCRUAL_BACKOFF_MAX_DELAY"); ok {
+		d, err := strconv.Atoi(maxDelay)
+		if err != nil {
+			log.Fatal("bad accrual backoff max delay value, must be int (seconds)")
+		}
+		cfg.AccrualBackoffMaxDelay = time.Duration(d) * time.Second
+	}
+	if jitter, ok := os.LookupEnv("ACCRUAL_BACKOFF_JITTER"); ok {
+		j, err := strconv.ParseFloat(jitter, 64)
+		if err != nil {
+			log.Fatal("bad accrual backoff jitter value, must be float (0..1)")
+		}
+		cfg.AccrualBackoffJitter = j
+	}
+	if maxElapsed, ok := os.LookupEnv("ACCRUAL_MAX_ELAPSED"); ok {
+		e, err := strconv.Atoi(maxElapsed)
+		if err != nil {
+			log.Fatal("bad accrual max elapsed value, must be int (seconds)")
+		}
+		cfg.AccrualMaxElapsed = time.Duration(e) * time.Second
+	}
+	if failures, ok := os.LookupEnv("ACCRUAL_BREAKER_FAILURES"); ok {
+		f, err := strconv.Atoi(failures)
+		if err != nil {
+			log.Fatal("bad accrual breaker failures value, must be int")
+		}
+		cfg.AccrualBreakerFailures = f
+	}
+	if cooldown, ok := os.LookupEnv("ACCRUAL_BREAKER_COOLDOWN"); ok {
+		c, err := strconv.Atoi(cooldown)
+		if err != nil {
+			log.Fatal("bad accrual breaker cooldown value, must be int (seconds)")
+		}
+		cfg.AccrualBreakerCooldown = time.Duration(c) * time.Second
+	}
+	if probes, ok := os.LookupEnv("ACCRUAL_BREAKER_PROBES"); ok {
+		p, err := strconv.Atoi(probes)
+		if err != nil {
+			log.Fatal("bad accrual breaker probes value, must be int")
+		}
+		cfg.AccrualBreakerProbes = p
+	}
+	if secret, ok := os.LookupEnv("ACCRUAL_WEBHOOK_SECRET"); ok {
+		cfg.AccrualWebhookSecret = secret
+	}
+	if interval, ok := os.LookupEnv("RECONCILE_INTERVAL"); ok {
+		i, err := strconv.Atoi(interval)
+		if err != nil {
+			log.Fatal("bad reconcile interval value, must be int (seconds)")
+		}
+		cfg.ReconcileInterval = time.Duration(i) * time.Second
+	}
+	if window, ok := os.LookupEnv("RECONCILE_WINDOW"); ok {
+		w, err := strconv.Atoi(window)
+		if err != nil {
+			log.Fatal("bad reconcile window value, must be int (seconds)")
+		}
+		cfg.ReconcileWindow = time.Duration(w) * time.Second
+	}
+	if apply, ok := os.LookupEnv("RECONCILE_APPLY"); ok {
+		a, err := strconv.ParseBool(apply)
+		if err != nil {
+			log.Fatal("bad reconcile apply value, must be bool")
+		}
+		cfg.ReconcileApply = a
+	}
+	if ttl, ok := os.LookupEnv("IDEMPOTENCY_TTL"); ok {
+		t, err := strconv.Atoi(ttl)
+		if err != nil {
+			log.Fatal("bad idempotency TTL value, must be int (seconds)")
+		}
+		cfg.IdempotencyTTL = time.Duration(t) * time.Second
+	}
+	if ttl, ok := os.LookupEnv("HOLD_TTL"); ok {
+		t, err := strconv.Atoi(ttl)
+		if err != nil {
+			log.Fatal("bad hold TTL value, must be int (seconds)")
+		}
+		cfg.HoldTTL = time.Duration(t) * time.Second
+	}
+	if token, ok := os.LookupEnv("ADMIN_TOKEN"); ok {
+		cfg.AdminToken = token
+	}
+	if secret, ok := os.LookupEnv("PARTNER_SECRET"); ok {
+		cfg.PartnerSecret = secret
+	}
+	if policy, ok := os.LookupEnv("CLAWBACK_POLICY"); ok {
+		cfg.ClawbackPolicy = policy
+	}
+	if months, ok := os.LookupEnv("POINTS_EXPIRE_MONTHS"); ok {
+		m, err := strconv.Atoi(months)
+		if err != nil {
+			log.Fatal("bad points expire months value, must be int (months)")
+		}
+		cfg.PointsExpireMonths = m
+	}
+	if soon, ok := os.LookupEnv("POINTS_EXPIRING_SOON"); ok {
+		s, err := strconv.Atoi(soon)
+		if err != nil {
+			log.Fatal("bad points expiring soon value, must be int (seconds)")
+		}
+		cfg.PointsExpiringSoon = time.Duration(s) * time.Second
+	}
+	if minSum, ok := os.LookupEnv("TRANSFER_MIN"); ok {
+		cfg.TransferMin = parseAmount(minSum, "transfer min")
+	}
+	if limit, ok := os.LookupEnv("TRANSFER_DAILY_LIMIT"); ok {
+		cfg.TransferDailyLimit = parseAmount(limit, "transfer daily limit")
+	}
+	if tiers, ok := os.LookupEnv("TIERS"); ok {
+		cfg.Tiers = tiers
+	}
+	if reward, ok := os.LookupEnv("REFERRAL_REWARD"); ok {
+		cfg.ReferralReward = parseAmount(reward, "referral reward")
+	}
+	if header, ok := os.LookupEnv("REFERRAL_IP_HEADER"); ok {
+		cfg.ReferralIPHeader = header
+	}
 	if secret, ok := os.LookupEnv("SECRET_KEY"); ok {
 		cfg.SecretKey = secret
 	}
@@ -94,3 +350,18 @@ func (cfg *Config) updateFromEnv() {
 		cfg.LogLevel = lvl
 	}
 }
+
+// Checks values which would break the app later rather than at startup.
+func (cfg *Config) validate() {
+	if cfg.AccrualPollingInterval <= 0 {
+		log.Fatal("bad accrual polling interval value, must be positive")
+	}
+}
+
+func parseAmount(s, name string) money.Amount {
+	a, err := money.Parse(s)
+	if err != nil || a < 0 {
+		log.Fatalf("bad %s value, must be a non-negative decimal with up to %d digits after the point", name, money.Scale)
+	}
+	return a
+}
@@ -0,0 +1,13 @@
+package expiry
+
+import (
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Points of the user which expire at the same day.
+type Expiring struct {
+	Sum       money.Amount `json:"sum"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
@@ -0,0 +1,124 @@
+package expiry
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+// Users who have points earned more than `months` ago, at most `limit` of them.
+func (r *repo) GetUsersWithExpired(ctx context.Context, months, limit int) ([]string, error) {
+	q := `SELECT DISTINCT user_id FROM ledger_lots
+	      WHERE remaining > 0 AND earned_at <= NOW() - make_interval(months => $1)
+	      LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, q, months, limit)
+	if err != nil {
+		return nil, fmt.Errorf("expiry/repo: failed getting users with expired points, %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	users := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("scan user id row failed: %w", err)
+		}
+		users = append(users, userID)
+	}
+	return users, nil
+}
+
+// Debits the points of the user earned more than `months` ago. The oldest lots
+// are taken first, so the debit takes exactly the expired ones. Points already
+// spent by an overdraft are written off without a debit.
+func (r *repo) ExpirePoints(ctx context.Context, userID string, months int) (money.Amount, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("expiry/repo: failed init expire transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	var balance money.Amount
+	err = tx.QueryRowContext(ctx, `SELECT balance FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("expiry/repo: failed getting balance of user `%s`, %w", userID, err)
+	}
+
+	var expired money.Amount
+	q := `SELECT COALESCE(SUM(remaining), 0) FROM ledger_lots
+	      WHERE user_id = $1 AND remaining > 0 AND earned_at <= NOW() - make_interval(months => $2)`
+	if err = tx.QueryRowContext(ctx, q, userID, months).Scan(&expired); err != nil {
+		return 0, fmt.Errorf("expiry/repo: failed getting expired points of user `%s`, %w", userID, err)
+	}
+
+	if expired > balance {
+		expired = balance
+	}
+	if expired > 0 {
+		err = ledger.Post(ctx, tx, &ledger.Posting{
+			UserID: userID,
+			Kind:   ledger.EXPIRATION,
+			Debit:  ledger.UserAccount(userID),
+			Credit: ledger.AccountExpired,
+			Amount: expired,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("expiry/repo: failed debiting expired points of user `%s`, %w", userID, err)
+		}
+	}
+
+	q = `UPDATE ledger_lots SET remaining = 0
+	     WHERE user_id = $1 AND remaining > 0 AND earned_at <= NOW() - make_interval(months => $2)`
+	if _, err = tx.ExecContext(ctx, q, userID, months); err != nil {
+		return 0, fmt.Errorf("expiry/repo: failed closing expired lots of user `%s`, %w", userID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("expiry/repo: failed committing expire transaction, %w", err)
+	}
+	return expired, nil
+}
+
+// Points of the user which expire during the `window`, grouped by day.
+func (r *repo) GetExpiring(ctx context.Context, userID string, months int, window time.Duration) ([]*Expiring, error) {
+	q := `SELECT date_trunc('day', earned_at + make_interval(months => $2)) AS expires_at, SUM(remaining)
+	      FROM ledger_lots
+	      WHERE user_id = $1 AND remaining > 0
+	        AND earned_at + make_interval(months => $2) <= NOW() + make_interval(secs => $3)
+	      GROUP BY expires_at ORDER BY expires_at`
+	rows, err := r.db.QueryContext(ctx, q, userID, months, window.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("expiry/repo: failed getting expiring points of user `%s`, %w", userID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	expiring := []*Expiring{}
+	for rows.Next() {
+		e := new(Expiring)
+		if err := rows.Scan(&e.ExpiresAt, &e.Sum); err != nil {
+			return nil, fmt.Errorf("scan expiring points row failed: %w", err)
+		}
+		expiring = append(expiring, e)
+	}
+	return expiring, nil
+}
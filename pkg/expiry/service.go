@@ -0,0 +1,80 @@
+package expiry
+
+import (
+	"context"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Max number of users whose points are expired in one pass.
+const expireBatchSize = 100
+
+type iExpiryRepo interface {
+	GetUsersWithExpired(ctx context.Context, months, limit int) ([]string, error)
+	ExpirePoints(ctx context.Context, userID string, months int) (money.Amount, error)
+	GetExpiring(ctx context.Context, userID string, months int, window time.Duration) ([]*Expiring, error)
+}
+
+type service struct {
+	repo       iExpiryRepo
+	months     int           // points expire this many months after they were earned, 0 disables expiration
+	soonWindow time.Duration // points expiring during this period are reported as expiring soon
+}
+
+func NewService(r iExpiryRepo, months int, soonWindow time.Duration) *service {
+	return &service{
+		repo:       r,
+		months:     months,
+		soonWindow: soonWindow,
+	}
+}
+
+// Points of the user which expire soon. Empty if expiration is disabled.
+func (s *service) ExpiringSoon(ctx context.Context, userID string) ([]*Expiring, error) {
+	if s.months <= 0 {
+		return []*Expiring{}, nil
+	}
+	return s.repo.GetExpiring(ctx, userID, s.months, s.soonWindow)
+}
+
+// Expires points every `interval` until `ctx` is done. Does nothing if expiration is disabled.
+func (s *service) RunScheduled(ctx context.Context, interval time.Duration) {
+	if s.months <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.expire(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *service) expire(ctx context.Context) {
+	for {
+		users, err := s.repo.GetUsersWithExpired(ctx, s.months, expireBatchSize)
+		if err != nil {
+			logger.Log(ctx).Error(err)
+			return
+		}
+		for _, userID := range users {
+			expired, err := s.repo.ExpirePoints(ctx, userID, s.months)
+			if err != nil {
+				logger.Log(ctx).Error(err)
+				return
+			}
+			logger.Log(ctx).Infof("expiry: %s points of user `%s` expired", expired, userID)
+		}
+		if len(users) < expireBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package idempotency
+
+import (
+	"net/http"
+	"time"
+)
+
+// The first response to a request with an `Idempotency-Key`.
+type Record struct {
+	UserID      string
+	Key         string
+	RequestHash []byte
+	StatusCode  int // 0 while the first request is still in progress
+	Headers     http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+func (r *Record) InProgress() bool {
+	return r.StatusCode == 0
+}
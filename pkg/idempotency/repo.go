@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+// Reserves the key for the request for `lease`, the response must be stored
+// before it's over. Returns the existing record and `false` if the key is already
+// taken. Expired keys and keys of requests which didn't finish in time are taken over.
+func (r *repo) Reserve(ctx context.Context, userID, key string, hash []byte, ttl, lease time.Duration) (*Record, bool, error) {
+	q := `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2
+	      AND (expires_at < NOW() OR status_code IS NULL AND locked_until < NOW())`
+	if _, err := r.db.ExecContext(ctx, q, userID, key); err != nil {
+		return nil, false, fmt.Errorf("idempotency/repo: failed deleting expired key, %w", err)
+	}
+
+	q = `INSERT INTO idempotency_keys(user_id, key, request_hash, expires_at, locked_until)
+	     VALUES($1, $2, $3, NOW() + make_interval(secs => $4), NOW() + make_interval(secs => $5))
+	     ON CONFLICT (user_id, key) DO NOTHING`
+	res, err := r.db.ExecContext(ctx, q, userID, key, hash, ttl.Seconds(), lease.Seconds())
+	if err != nil {
+		return nil, false, fmt.Errorf("idempotency/repo: failed reserving key, %w", err)
+	}
+	if inserted, err := res.RowsAffected(); err == nil && inserted == 1 {
+		return nil, true, nil
+	}
+
+	rec, err := r.get(ctx, userID, key)
+	if err != nil {
+		return nil, false, err
+	}
+	return rec, false, nil
+}
+
+func (r *repo) get(ctx context.Context, userID, key string) (*Record, error) {
+	q := `SELECT request_hash, COALESCE(status_code, 0), COALESCE(headers, '{}'), COALESCE(body, ''::bytea), expires_at
+	      FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+	rec := &Record{UserID: userID, Key: key}
+	var headers []byte
+	err := r.db.QueryRowContext(ctx, q, userID, key).
+		Scan(&rec.RequestHash, &rec.StatusCode, &headers, &rec.Body, &rec.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("idempotency/repo: failed getting key, %w", err)
+	}
+	if err := json.Unmarshal(headers, &rec.Headers); err != nil {
+		return nil, fmt.Errorf("idempotency/repo: failed parsing stored headers, %w", err)
+	}
+	return rec, nil
+}
+
+// Stores the response of the first request.
+func (r *repo) Complete(ctx context.Context, rec *Record) error {
+	headers, err := json.Marshal(rec.Headers)
+	if err != nil {
+		return fmt.Errorf("idempotency/repo: failed encoding headers, %w", err)
+	}
+	q := `UPDATE idempotency_keys SET status_code = $1, headers = $2, body = $3, locked_until = NULL
+	      WHERE user_id = $4 AND key = $5`
+	_, err = r.db.ExecContext(ctx, q, rec.StatusCode, headers, rec.Body, rec.UserID, rec.Key)
+	if err != nil {
+		return fmt.Errorf("idempotency/repo: failed storing response, %w", err)
+	}
+	return nil
+}
+
+// Frees the key, so the request can be retried.
+func (r *repo) Release(ctx context.Context, userID, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key)
+	if err != nil {
+		return fmt.Errorf("idempotency/repo: failed releasing key, %w", err)
+	}
+	return nil
+}
+
+func (r *repo) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("idempotency/repo: failed deleting expired keys, %w", err)
+	}
+	return res.RowsAffected()
+}
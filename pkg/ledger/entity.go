@@ -0,0 +1,116 @@
+package ledger
+
+import (
+	"strings"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Kinds of postings.
+const (
+	ACCRUAL    = "ACCRUAL"    // points credited for a PROCESSED order
+	WITHDRAWAL = "WITHDRAWAL" // points spent on an order
+	ADJUSTMENT = "ADJUSTMENT" // manual or reconciliation correction
+	REVERSAL   = "REVERSAL"   // undoing a previous posting
+	HOLD       = "HOLD"       // points reserved for a purchase which isn't complete yet
+	RELEASE    = "RELEASE"    // reserved points returned to the user
+	CLAWBACK   = "CLAWBACK"   // accrual taken back from a cancelled order
+	EXPIRATION = "EXPIRATION" // points not spent in time
+	TRANSFER   = "TRANSFER"   // points sent by one user to another
+	BONUS      = "BONUS"      // extra points on top of an order accrual
+	VOUCHER    = "VOUCHER"    // points for a redeemed voucher code
+)
+
+// Reports whether `kind` is a known kind of postings.
+func IsKind(kind string) bool {
+	switch kind {
+	case ACCRUAL, WITHDRAWAL, ADJUSTMENT, REVERSAL, HOLD, RELEASE, CLAWBACK, EXPIRATION, TRANSFER, BONUS, VOUCHER:
+		return true
+	}
+	return false
+}
+
+// System accounts, the counterparts of user accounts.
+const (
+	AccountAccrual     = "system:accrual"
+	AccountWithdrawals = "system:withdrawals"
+	AccountAdjustments = "system:adjustments"
+	AccountExpired     = "system:expired"
+	AccountBonus       = "system:bonus"
+	AccountCampaigns   = "system:campaigns"
+	AccountReferrals   = "system:referrals"
+	AccountVouchers    = "system:vouchers"
+)
+
+const (
+	userAccountPrefix = "user:"
+	heldAccountPrefix = "held:"
+)
+
+// Every posting moves `Amount` from the `Debit` account to the `Credit` one,
+// so the sum over all accounts is always zero. The balance of an account is
+// credits minus debits.
+type Posting struct {
+	ID           int64
+	UserID       string // whose history the posting belongs to
+	Kind         string
+	Debit        string
+	Credit       string
+	Amount       money.Amount
+	OrderID      string // optional reference to the order
+	WithdrawalID int64  // optional reference to the withdrawal, 0 if none
+	TransferID   int64  // optional reference to the transfer, 0 if none
+	CampaignID   int64  // optional reference to the campaign, 0 if none
+	ReferralID   int64  // optional reference to the referral, 0 if none
+	RedemptionID int64  // optional reference to the voucher redemption, 0 if none
+	CreatedAt    time.Time
+	// User accounts can't go below zero unless it's allowed explicitly,
+	// e.g. for corrections which must be applied anyway.
+	AllowOverdraft bool
+}
+
+// Cached `users` columns which don't match the ledger.
+type Inconsistency struct {
+	UserID          string       `json:"user_id"`
+	CachedBalance   money.Amount `json:"cached_balance"`
+	LedgerBalance   money.Amount `json:"ledger_balance"`
+	CachedHeld      money.Amount `json:"cached_held"`
+	LedgerHeld      money.Amount `json:"ledger_held"`
+	CachedWithdrawn money.Amount `json:"cached_withdrawn"`
+	LedgerWithdrawn money.Amount `json:"ledger_withdrawn"`
+}
+
+// Totals of the user derived from the ledger.
+type UserTotals struct {
+	Balance   money.Amount
+	Held      money.Amount
+	Withdrawn money.Amount
+}
+
+// The account holding points available to the user.
+func UserAccount(userID string) string {
+	return userAccountPrefix + userID
+}
+
+// The account holding points reserved by the user's active holds.
+func HeldAccount(userID string) string {
+	return heldAccountPrefix + userID
+}
+
+// Returns the user ID if `account` is a user account.
+func accountUser(account string) (string, bool) {
+	return accountOwner(account, userAccountPrefix)
+}
+
+// Returns the user ID if `account` is a held account.
+func accountHolder(account string) (string, bool) {
+	return accountOwner(account, heldAccountPrefix)
+}
+
+func accountOwner(account, prefix string) (string, bool) {
+	if !strings.HasPrefix(account, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(account, prefix), true
+}
@@ -0,0 +1,121 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Points credited to a user account are tracked in lots, so they can expire
+// in the order they were earned. Debits of the user account take points from
+// the oldest lots first. Releases and reversals return points to the lots
+// the order took them from, so the returned points keep their original age.
+// Transfers move the lots they took to the receiver with their age, so points
+// passed between users expire when they would have expired with the sender.
+//
+// All lot changes go through `Post` while the `users` row is locked by the
+// cache update, so concurrent postings of the same user don't interleave.
+
+// Kinds which return points previously debited for the same order.
+func restoresLots(kind string) bool {
+	return kind == RELEASE || kind == REVERSAL
+}
+
+// Takes `amount` from the oldest lots of the user. Overdrafts take what's there.
+func takeFromLots(ctx context.Context, tx iTx, userID string, postingID int64, amount money.Amount) error {
+	q := `WITH lots AS (
+	        SELECT id, LEAST(remaining,
+	          GREATEST($2::numeric - (SUM(remaining) OVER (ORDER BY earned_at, id) - remaining), 0)) AS take
+	        FROM ledger_lots WHERE user_id = $1 AND remaining > 0
+	      ), taken AS (
+	        UPDATE ledger_lots l SET remaining = l.remaining - lots.take
+	        FROM lots WHERE l.id = lots.id AND lots.take > 0
+	        RETURNING l.id, lots.take
+	      )
+	      INSERT INTO ledger_lot_usages(posting_id, lot_id, amount) SELECT $3::bigint, id, take FROM taken`
+	if _, err := tx.ExecContext(ctx, q, userID, amount, postingID); err != nil {
+		return fmt.Errorf("ledger: failed taking points from lots of user `%s`, %w", userID, err)
+	}
+	return nil
+}
+
+// Puts the credited points into lots: returned points go back where the order
+// took them from, transferred ones keep the age they had, the rest makes a new lot. Lots never hold more than the balance,
+// so points covering a debt don't start a lot.
+func putIntoLots(ctx context.Context, tx iTx, p *Posting, userID string) error {
+	restored := money.Amount(0)
+	if restoresLots(p.Kind) && p.OrderID != "" {
+		q := `WITH taken AS (
+		        SELECT u.lot_id, SUM(u.amount) AS amount
+		        FROM ledger_lot_usages u JOIN ledger l ON l.id = u.posting_id
+		        WHERE l.user_id = $1 AND l.order_id = $2
+		        GROUP BY u.lot_id HAVING SUM(u.amount) > 0
+		      ), lots AS (
+		        SELECT t.lot_id AS id, LEAST(t.amount,
+		          GREATEST($3::numeric - (SUM(t.amount) OVER (ORDER BY l.earned_at DESC, l.id DESC) - t.amount), 0)) AS give
+		        FROM taken t JOIN ledger_lots l ON l.id = t.lot_id
+		      ), given AS (
+		        UPDATE ledger_lots l SET remaining = l.remaining + lots.give
+		        FROM lots WHERE l.id = lots.id AND lots.give > 0
+		        RETURNING l.id, lots.give
+		      ), usages AS (
+		        INSERT INTO ledger_lot_usages(posting_id, lot_id, amount) SELECT $4::bigint, id, -give FROM given
+		      )
+		      SELECT COALESCE(SUM(give), 0) FROM given`
+		err := tx.QueryRowContext(ctx, q, userID, p.OrderID, p.Amount, p.ID).Scan(&restored)
+		if err != nil {
+			return fmt.Errorf("ledger: failed returning points to lots of user `%s`, %w", userID, err)
+		}
+	}
+	if p.Kind == TRANSFER {
+		moved, err := moveLots(ctx, tx, p, userID)
+		if err != nil {
+			return err
+		}
+		restored += moved
+	}
+	if restored >= p.Amount {
+		return nil
+	}
+
+	q := `INSERT INTO ledger_lots(user_id, posting_id, amount, remaining)
+	      SELECT $1::integer, $2::bigint, a, a FROM (
+	        SELECT LEAST($3::numeric,
+	          u.balance - COALESCE((SELECT SUM(remaining) FROM ledger_lots WHERE user_id = $1), 0)) AS a
+	        FROM users u WHERE u.id = $1
+	      ) lot
+	      WHERE a > 0`
+	if _, err := tx.ExecContext(ctx, q, userID, p.ID, p.Amount-restored); err != nil {
+		return fmt.Errorf("ledger: failed adding lot of user `%s`, %w", userID, err)
+	}
+	return nil
+}
+
+// Copies the lots the posting took from the sender to the receiver, keeping
+// their `earned_at`. Must be called after `takeFromLots` of the same posting.
+// Returns how much is moved.
+func moveLots(ctx context.Context, tx iTx, p *Posting, userID string) (money.Amount, error) {
+	q := `WITH taken AS (
+	        SELECT l.id, l.earned_at, u.amount
+	        FROM ledger_lot_usages u JOIN ledger_lots l ON l.id = u.lot_id
+	        WHERE u.posting_id = $2 AND u.amount > 0
+	      ), room AS (
+	        SELECT u.balance - COALESCE((SELECT SUM(remaining) FROM ledger_lots WHERE user_id = $1), 0) AS r
+	        FROM users u WHERE u.id = $1
+	      ), lots AS (
+	        SELECT t.earned_at, LEAST(t.amount,
+	          GREATEST(room.r - (SUM(t.amount) OVER (ORDER BY t.earned_at, t.id) - t.amount), 0)) AS a
+	        FROM taken t, room
+	      ), moved AS (
+	        INSERT INTO ledger_lots(user_id, posting_id, amount, remaining, earned_at)
+	        SELECT $1::integer, $2::bigint, a, a, earned_at FROM lots WHERE a > 0
+	        RETURNING amount
+	      )
+	      SELECT COALESCE(SUM(amount), 0) FROM moved`
+	var moved money.Amount
+	if err := tx.QueryRowContext(ctx, q, userID, p.ID).Scan(&moved); err != nil {
+		return 0, fmt.Errorf("ledger: failed moving lots to user `%s`, %w", userID, err)
+	}
+	return moved, nil
+}
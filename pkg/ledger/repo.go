@@ -0,0 +1,196 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+var (
+	ErrBadPosting        = errors.New("ledger: bad posting")
+	ErrInsufficientFunds = errors.New("ledger: insufficient funds")
+)
+
+type iExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type iQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type iTx interface {
+	iExecer
+	iQuerier
+}
+
+// Appends the posting to the ledger and updates the cached `users.balance`,
+// `users.held` and `users.withdrawn` columns. Must be called inside the transaction
+// which makes the change the posting describes. Sets `p.ID` and keeps
+// the lots of the user account in sync, see `lots.go`.
+//
+// Debiting a user or held account is atomic and conditional: it fails with
+// `ErrInsufficientFunds` if the balance isn't enough, even under concurrent debits.
+func Post(ctx context.Context, tx iTx, p *Posting) error {
+	if p.Amount <= 0 || p.Debit == p.Credit || p.UserID == "" {
+		return fmt.Errorf("%w: %s %s from `%s` to `%s`", ErrBadPosting, p.Kind, p.Amount, p.Debit, p.Credit)
+	}
+
+	// Goes first: the row lock serializes concurrent debits of the same user
+	if userID, ok := accountUser(p.Debit); ok {
+		if err := debitCache(ctx, tx, userID, "balance", p.Amount, p.AllowOverdraft); err != nil {
+			return err
+		}
+	}
+	if userID, ok := accountHolder(p.Debit); ok {
+		if err := debitCache(ctx, tx, userID, "held", p.Amount, false); err != nil {
+			return err
+		}
+	}
+
+	q := `INSERT INTO ledger(user_id, kind, debit_account, credit_account, amount, order_id,
+	        withdrawal_id, transfer_id, campaign_id, referral_id, voucher_redemption_id)
+	      VALUES($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, 0), NULLIF($8, 0), NULLIF($9, 0), NULLIF($10, 0),
+	        NULLIF($11, 0))
+	      RETURNING id, created_at`
+	err := tx.QueryRowContext(ctx, q, p.UserID, p.Kind, p.Debit, p.Credit, p.Amount,
+		p.OrderID, p.WithdrawalID, p.TransferID, p.CampaignID, p.ReferralID,
+		p.RedemptionID).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ledger: failed inserting %s posting, %w", p.Kind, err)
+	}
+
+	if userID, ok := accountUser(p.Credit); ok {
+		if err := updateCache(ctx, tx, userID, "balance", p.Amount); err != nil {
+			return err
+		}
+	}
+	if userID, ok := accountHolder(p.Credit); ok {
+		if err := updateCache(ctx, tx, userID, "held", p.Amount); err != nil {
+			return err
+		}
+	}
+	if p.Credit == AccountWithdrawals {
+		if err := updateCache(ctx, tx, p.UserID, "withdrawn", p.Amount); err != nil {
+			return err
+		}
+	}
+	if p.Debit == AccountWithdrawals {
+		if err := updateCache(ctx, tx, p.UserID, "withdrawn", -p.Amount); err != nil {
+			return err
+		}
+	}
+
+	if userID, ok := accountUser(p.Debit); ok {
+		if err := takeFromLots(ctx, tx, userID, p.ID, p.Amount); err != nil {
+			return err
+		}
+	}
+	if userID, ok := accountUser(p.Credit); ok {
+		if err := putIntoLots(ctx, tx, p, userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func debitCache(ctx context.Context, tx iExecer, userID, column string, amount money.Amount, allowOverdraft bool) error {
+	// `column` is never user input
+	q := fmt.Sprintf(`UPDATE users SET %[1]s = %[1]s - $1 WHERE id = $2 AND ($3 OR %[1]s >= $1)`, column)
+	res, err := tx.ExecContext(ctx, q, amount, userID, allowOverdraft)
+	if err != nil {
+		return fmt.Errorf("ledger: failed debiting user `%s`, %w", userID, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ledger: failed debiting user `%s`, %w", userID, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w: user `%s` can't pay %s", ErrInsufficientFunds, userID, amount)
+	}
+	return nil
+}
+
+func updateCache(ctx context.Context, tx iExecer, userID, column string, delta money.Amount) error {
+	// `column` is never user input
+	q := fmt.Sprintf(`UPDATE users SET %[1]s = %[1]s + $1 WHERE id = $2`, column)
+	if _, err := tx.ExecContext(ctx, q, delta, userID); err != nil {
+		return fmt.Errorf("ledger: failed updating cached %s of user `%s`, %w", column, userID, err)
+	}
+	return nil
+}
+
+// Balance, held and withdrawn totals of the user derived from the ledger.
+// Scans the user's whole history, so it's for consistency checks only,
+// the current balance is in the cached `users` columns.
+func Totals(ctx context.Context, db iQuerier, userID string) (*UserTotals, error) {
+	q := `SELECT
+	        COALESCE(SUM(CASE WHEN credit_account = $2 THEN amount
+	                          WHEN debit_account = $2 THEN -amount ELSE 0 END), 0),
+	        COALESCE(SUM(CASE WHEN credit_account = $3 THEN amount
+	                          WHEN debit_account = $3 THEN -amount ELSE 0 END), 0),
+	        COALESCE(SUM(CASE WHEN user_id = $1 AND credit_account = $4 THEN amount
+	                          WHEN user_id = $1 AND debit_account = $4 THEN -amount ELSE 0 END), 0)
+	      FROM ledger
+	      WHERE user_id = $1 OR debit_account IN ($2, $3) OR credit_account IN ($2, $3)`
+	t := &UserTotals{}
+	err := db.QueryRowContext(ctx, q, userID, UserAccount(userID), HeldAccount(userID), AccountWithdrawals).
+		Scan(&t.Balance, &t.Held, &t.Withdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: failed getting totals of user `%s`, %w", userID, err)
+	}
+	return t, nil
+}
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+// Compares the cached `users` columns with the totals derived from the ledger.
+func (r *repo) CheckConsistency(ctx context.Context) ([]*Inconsistency, error) {
+	q := `WITH totals AS (
+	        SELECT u.id, u.balance, u.held, u.withdrawn,
+	          COALESCE((SELECT SUM(CASE WHEN l.credit_account = 'user:' || u.id THEN l.amount ELSE -l.amount END)
+	                    FROM ledger l
+	                    WHERE l.debit_account = 'user:' || u.id OR l.credit_account = 'user:' || u.id), 0) AS l_balance,
+	          COALESCE((SELECT SUM(CASE WHEN l.credit_account = 'held:' || u.id THEN l.amount ELSE -l.amount END)
+	                    FROM ledger l
+	                    WHERE l.debit_account = 'held:' || u.id OR l.credit_account = 'held:' || u.id), 0) AS l_held,
+	          COALESCE((SELECT SUM(CASE WHEN l.credit_account = $1 THEN l.amount
+	                                    WHEN l.debit_account = $1 THEN -l.amount ELSE 0 END)
+	                    FROM ledger l WHERE l.user_id = u.id), 0) AS l_withdrawn
+	        FROM users u
+	      )
+	      SELECT id, balance, l_balance, held, l_held, withdrawn, l_withdrawn FROM totals
+	      WHERE balance <> l_balance OR held <> l_held OR withdrawn <> l_withdrawn
+	      ORDER BY id`
+	rows, err := r.db.QueryContext(ctx, q, AccountWithdrawals)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: failed checking consistency, %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	found := []*Inconsistency{}
+	for rows.Next() {
+		i := new(Inconsistency)
+		if err := rows.Scan(&i.UserID, &i.CachedBalance, &i.LedgerBalance,
+			&i.CachedHeld, &i.LedgerHeld, &i.CachedWithdrawn, &i.LedgerWithdrawn); err != nil {
+			return nil, fmt.Errorf("scan inconsistency row failed: %w", err)
+		}
+		found = append(found, i)
+	}
+	return found, nil
+}
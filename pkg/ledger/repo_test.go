@@ -0,0 +1,233 @@
+package ledger
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Postings need the real schema, tests which post run only if the database is given,
+// e.g. `TEST_DATABASE_URI=postgresql://localhost/gophermart_test?sslmode=disable`.
+var testDB *sql.DB
+
+func TestMain(m *testing.M) {
+	if uri := os.Getenv("TEST_DATABASE_URI"); uri != "" {
+		db, err := sql.Open("pgx", uri)
+		if err != nil {
+			log.Fatalf("can't open test database: %v", err)
+		}
+		if err := migrateTestDB(db); err != nil {
+			log.Fatalf("can't migrate test database: %v", err)
+		}
+		testDB = db
+	}
+	os.Exit(m.Run())
+}
+
+func migrateTestDB(db *sql.DB) error {
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs("../../migrations")
+	if err != nil {
+		return err
+	}
+	m, err := migrate.NewWithDatabaseInstance("file:///"+path, "postgres", driver)
+	if err != nil {
+		return err
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+func requireDB(t *testing.T) {
+	t.Helper()
+	if testDB == nil {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+}
+
+// Adds a user with zero balances, it's removed when the test is over.
+func addTestUser(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	suffix := hex.EncodeToString(b)
+
+	var id string
+	err := testDB.QueryRow(`INSERT INTO users(login, password, referral_code) VALUES($1, $2, $3) RETURNING id`,
+		"ledger-test-"+suffix, []byte{}, suffix).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = testDB.Exec(`DELETE FROM users WHERE id = $1`, id)
+	})
+	return id
+}
+
+func cached(t *testing.T, q iQuerier, userID string) (balance, held, withdrawn money.Amount) {
+	t.Helper()
+	err := q.QueryRowContext(context.Background(), `SELECT balance, held, withdrawn FROM users WHERE id = $1`, userID).
+		Scan(&balance, &held, &withdrawn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// Posts in a transaction of its own. Safe to call from other goroutines.
+func post(t *testing.T, p *Posting) error {
+	t.Helper()
+	tx, err := testDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := Post(context.Background(), tx, p); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Checks the cached columns and that they match the ledger.
+func checkUser(t *testing.T, userID string, balance, held, withdrawn money.Amount) {
+	t.Helper()
+	b, h, w := cached(t, testDB, userID)
+	if b != balance || h != held || w != withdrawn {
+		t.Errorf("cached balance/held/withdrawn = %s/%s/%s, want %s/%s/%s", b, h, w, balance, held, withdrawn)
+	}
+	totals, err := Totals(context.Background(), testDB, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Balance != b || totals.Held != h || totals.Withdrawn != w {
+		t.Errorf("ledger totals %+v don't match the cached %s/%s/%s", totals, b, h, w)
+	}
+}
+
+func TestPostRejectsBadPostings(t *testing.T) {
+	// Checked before anything is written, so no transaction is needed
+	bad := []*Posting{
+		{UserID: "1", Kind: ACCRUAL, Debit: AccountAccrual, Credit: UserAccount("1"), Amount: 0},
+		{UserID: "1", Kind: ACCRUAL, Debit: AccountAccrual, Credit: UserAccount("1"), Amount: -100},
+		{UserID: "1", Kind: ADJUSTMENT, Debit: UserAccount("1"), Credit: UserAccount("1"), Amount: 100},
+		{Kind: ACCRUAL, Debit: AccountAccrual, Credit: UserAccount("1"), Amount: 100},
+	}
+	for _, p := range bad {
+		if err := Post(context.Background(), nil, p); !errors.Is(err, ErrBadPosting) {
+			t.Errorf("Post(%s %s from %q to %q) = %v, want ErrBadPosting", p.Kind, p.Amount, p.Debit, p.Credit, err)
+		}
+	}
+}
+
+func TestPostUpdatesCachedBalances(t *testing.T) {
+	requireDB(t)
+	userID := addTestUser(t)
+
+	accrual := &Posting{UserID: userID, Kind: ACCRUAL, Debit: AccountAccrual, Credit: UserAccount(userID), Amount: 10000}
+	if err := post(t, accrual); err != nil {
+		t.Fatal(err)
+	}
+	if accrual.ID == 0 || accrual.CreatedAt.IsZero() {
+		t.Errorf("posting ID and time aren't set: %+v", accrual)
+	}
+	checkUser(t, userID, 10000, 0, 0)
+
+	steps := []*Posting{
+		{UserID: userID, Kind: HOLD, Debit: UserAccount(userID), Credit: HeldAccount(userID), Amount: 3000},
+		{UserID: userID, Kind: WITHDRAWAL, Debit: HeldAccount(userID), Credit: AccountWithdrawals, Amount: 2000},
+		{UserID: userID, Kind: RELEASE, Debit: HeldAccount(userID), Credit: UserAccount(userID), Amount: 1000},
+		{UserID: userID, Kind: WITHDRAWAL, Debit: UserAccount(userID), Credit: AccountWithdrawals, Amount: 2550},
+		{UserID: userID, Kind: REVERSAL, Debit: AccountWithdrawals, Credit: UserAccount(userID), Amount: 550},
+	}
+	for _, p := range steps {
+		if err := post(t, p); err != nil {
+			t.Fatalf("%s %s: %v", p.Kind, p.Amount, err)
+		}
+	}
+	// 100 - 30 held + 10 released - 25.50 + 5.50 reversed, 20 + 25.50 - 5.50 withdrawn
+	checkUser(t, userID, 6000, 0, 4000)
+}
+
+func TestPostDebitIsConditional(t *testing.T) {
+	requireDB(t)
+	userID := addTestUser(t)
+	if err := post(t, &Posting{UserID: userID, Kind: ACCRUAL, Debit: AccountAccrual, Credit: UserAccount(userID), Amount: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	overspend := &Posting{UserID: userID, Kind: WITHDRAWAL, Debit: UserAccount(userID), Credit: AccountWithdrawals, Amount: 1001}
+	if err := post(t, overspend); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("withdrawing more than the balance = %v, want ErrInsufficientFunds", err)
+	}
+	overhold := &Posting{UserID: userID, Kind: WITHDRAWAL, Debit: HeldAccount(userID), Credit: AccountWithdrawals, Amount: 1}
+	if err := post(t, overhold); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("capturing more than is held = %v, want ErrInsufficientFunds", err)
+	}
+	checkUser(t, userID, 1000, 0, 0)
+
+	// Corrections go through even if the points are spent
+	correction := &Posting{UserID: userID, Kind: ADJUSTMENT, Debit: UserAccount(userID), Credit: AccountAdjustments,
+		Amount: 1500, AllowOverdraft: true}
+	if err := post(t, correction); err != nil {
+		t.Fatal(err)
+	}
+	checkUser(t, userID, -500, 0, 0)
+}
+
+func TestPostConcurrentDebits(t *testing.T) {
+	requireDB(t)
+	userID := addTestUser(t)
+	if err := post(t, &Posting{UserID: userID, Kind: ACCRUAL, Debit: AccountAccrual, Credit: UserAccount(userID), Amount: 10000}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each debit alone fits the balance, any two together don't
+	const debits = 5
+	errs := make(chan error, debits)
+	var wg sync.WaitGroup
+	for i := 0; i < debits; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- post(t, &Posting{UserID: userID, Kind: WITHDRAWAL, Debit: UserAccount(userID),
+				Credit: AccountWithdrawals, Amount: 6000})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrInsufficientFunds):
+			t.Errorf("concurrent debit failed with %v, want ErrInsufficientFunds", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent debits succeeded, want exactly one", succeeded)
+	}
+	checkUser(t, userID, 4000, 0, 6000)
+}
@@ -16,9 +16,15 @@ type (
 	}
 )
 
-const LoggerKey CtxLoggerKey = "logger"
+const (
+	LoggerKey    CtxLoggerKey = "logger"
+	RequestIDKey CtxLoggerKey = "requestID"
+)
 
-var fallbackLogger *zap.SugaredLogger
+var (
+	fallbackLogger *zap.SugaredLogger
+	baseLogger     *zap.Logger
+)
 
 // Logging function (a Zap wrapper) which considers context.
 // Usage example: `Log(ctx).Error("Error level")` etc. See the Zap docs.
@@ -33,6 +39,34 @@ func Log(ctx context.Context) *zap.SugaredLogger {
 	return zap
 }
 
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, ok := ctx.Value(RequestIDKey).(string)
+	if !ok {
+		return "-"
+	}
+	return requestID
+}
+
+// Returns a context carrying `traceID` and a logger which reports it.
+// Used by background jobs to keep the trace-id of the request that started them.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	if baseLogger == nil {
+		return ctx
+	}
+	if traceID == "" {
+		traceID = "-"
+	}
+	ctxLogger := baseLogger.With(
+		zap.String("trace-id", traceID),
+	).WithOptions(
+		zap.AddCaller(),
+		zap.AddCallerSkip(1),
+		zap.AddStacktrace(zap.ErrorLevel),
+	).Sugar()
+	ctx = context.WithValue(ctx, RequestIDKey, traceID)
+	return context.WithValue(ctx, LoggerKey, ctxLogger)
+}
+
 func Run(level string) *Logger {
 	var zapLogger *zap.Logger
 
@@ -67,6 +101,7 @@ func Run(level string) *Logger {
 	defer zapLogger.Sync()
 
 	logger := &Logger{zapLogger}
+	baseLogger = zapLogger
 
 	fallbackLogger = zapLogger.With(
 		zap.String("logger", "fallbackLogger"),
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+)
+
+type adminMiddleware struct {
+	token []byte
+}
+
+// Guards the support API with a static token. The API is disabled if the token is empty.
+func NewAdminMiddleware(token string) *adminMiddleware {
+	return &adminMiddleware{
+		token: []byte(token),
+	}
+}
+
+func (a *adminMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if len(a.token) == 0 {
+			common.WriteMsg(w, "admin API is disabled", http.StatusNotFound)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), a.token) != 1 {
+			logger.Log(r.Context()).Errorf("admin: bad token")
+			common.WriteMsg(w, "authorization failed", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
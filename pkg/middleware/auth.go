@@ -22,13 +22,16 @@ type authMiddleware struct {
 	repo           iUserRepo
 	sessionService iSessionService
 	noAuthUrls     map[string]struct{}
+	noAuthPrefixes []string // subtrees with their own authorization
 }
 
-func NewAuthMiddleware(sess iSessionService, r iUserRepo, noAuthUrls map[string]struct{}) *authMiddleware {
+func NewAuthMiddleware(sess iSessionService, r iUserRepo, noAuthUrls map[string]struct{},
+	noAuthPrefixes ...string) *authMiddleware {
 	return &authMiddleware{
 		repo:           r,
 		sessionService: sess,
 		noAuthUrls:     noAuthUrls,
+		noAuthPrefixes: noAuthPrefixes,
 	}
 }
 
@@ -38,6 +41,12 @@ func (a *authMiddleware) Middleware(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
+		for _, prefix := range a.noAuthPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
 
 		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 		currentSession, err := a.sessionService.GetUserSession(token)
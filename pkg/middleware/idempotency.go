@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/idempotency"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	replayedHeader       = "Idempotent-Replayed"
+	maxIdempotencyKeyLen = 255
+	maxIdempotentBody    = 1 << 20
+	// A request must finish within the lease, after that its key is taken over
+	// by a retry, e.g. if the process died mid-request.
+	idempotencyLease = time.Minute
+	// Keys are stored and released even if the client is gone.
+	idempotencySaveTimeout = 5 * time.Second
+)
+
+// Headers which belong to a particular response and are not replayed.
+var notReplayedHeaders = []string{"Date", "Content-Length", "Trace-Id", "X-Request-Id"}
+
+type iIdempotencyRepo interface {
+	Reserve(ctx context.Context, userID, key string, hash []byte, ttl, lease time.Duration) (*idempotency.Record, bool, error)
+	Complete(ctx context.Context, rec *idempotency.Record) error
+	Release(ctx context.Context, userID, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type idempotencyMiddleware struct {
+	repo iIdempotencyRepo
+	ttl  time.Duration
+}
+
+func NewIdempotencyMiddleware(r iIdempotencyRepo, ttl time.Duration) *idempotencyMiddleware {
+	return &idempotencyMiddleware{
+		repo: r,
+		ttl:  ttl,
+	}
+}
+
+// Makes retries of a request with the same `Idempotency-Key` safe: the first
+// response is stored per user and replayed for retries. Reusing the key for
+// a different request is rejected. Must run after the auth middleware.
+func (m *idempotencyMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(key) > maxIdempotencyKeyLen {
+			common.WriteMsg(w, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		userID, err := session.GetAuthUserID(r.Context())
+		if err != nil {
+			common.WriteMsg(w, "authorization failed", http.StatusUnauthorized)
+			return
+		}
+
+		// One byte over the limit tells a too large body from one of exactly the limit
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			common.WriteMsg(w, "request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(r, body)
+		rec, reserved, err := m.repo.Reserve(r.Context(), userID, key, hash, m.ttl, idempotencyLease)
+		if err != nil {
+			logger.Log(r.Context()).Errorf("idempotency: can't reserve key `%s`, %v", key, err)
+			common.WriteMsg(w, "can't process request", http.StatusInternalServerError)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case !bytes.Equal(rec.RequestHash, hash):
+				common.WriteMsg(w, "idempotency key is already used for another request", http.StatusUnprocessableEntity)
+			case rec.InProgress():
+				common.WriteMsg(w, "request with this idempotency key is in progress", http.StatusConflict)
+			default:
+				replay(w, rec)
+			}
+			return
+		}
+
+		// The request context is cancelled when the client disconnects
+		saveCtx, cancel := context.WithTimeout(
+			logger.WithTraceID(context.Background(), logger.RequestIDFromContext(r.Context())), idempotencySaveTimeout)
+		defer cancel()
+
+		rr := &responseRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// The handler failed or panicked, let the request be retried
+			p := recover()
+			m.release(saveCtx, userID, key)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rr, r)
+
+		if rr.status == 0 {
+			rr.status = http.StatusOK
+		}
+		// Failed requests may be retried with the same key
+		if rr.status >= http.StatusInternalServerError {
+			return
+		}
+
+		headers := w.Header().Clone()
+		for _, h := range notReplayedHeaders {
+			headers.Del(h)
+		}
+		err = m.repo.Complete(saveCtx, &idempotency.Record{
+			UserID:     userID,
+			Key:        key,
+			StatusCode: rr.status,
+			Headers:    headers,
+			Body:       rr.body.Bytes(),
+		})
+		if err != nil {
+			logger.Log(r.Context()).Error(err)
+		}
+		completed = true
+	})
+}
+
+func (m *idempotencyMiddleware) release(ctx context.Context, userID, key string) {
+	if err := m.repo.Release(ctx, userID, key); err != nil {
+		logger.Log(ctx).Error(err)
+	}
+}
+
+// Deletes expired keys every `interval` until `ctx` is done.
+func (m *idempotencyMiddleware) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.repo.DeleteExpired(ctx); err != nil {
+				logger.Log(ctx).Error(err)
+			}
+		}
+	}
+}
+
+func replay(w http.ResponseWriter, rec *idempotency.Record) {
+	for name, values := range rec.Headers {
+		w.Header()[name] = values
+	}
+	w.Header().Set(replayedHeader, "true")
+	w.WriteHeader(rec.StatusCode)
+	_, _ = w.Write(rec.Body)
+}
+
+func requestHash(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.status == 0 {
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/idempotency"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
+)
+
+// In-memory keys with the same semantics as the Postgres repo, without expiry.
+type memIdempotencyRepo struct {
+	mu      sync.Mutex
+	records map[string]*idempotency.Record
+}
+
+func newMemIdempotencyRepo() *memIdempotencyRepo {
+	return &memIdempotencyRepo{records: map[string]*idempotency.Record{}}
+}
+
+func (r *memIdempotencyRepo) Reserve(ctx context.Context, userID, key string, hash []byte, ttl, lease time.Duration) (*idempotency.Record, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.records[userID+"/"+key]; ok {
+		return rec, false, nil
+	}
+	r.records[userID+"/"+key] = &idempotency.Record{UserID: userID, Key: key, RequestHash: hash}
+	return nil, true, nil
+}
+
+func (r *memIdempotencyRepo) Complete(ctx context.Context, rec *idempotency.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := r.records[rec.UserID+"/"+rec.Key]
+	stored.StatusCode, stored.Headers, stored.Body = rec.StatusCode, rec.Headers, rec.Body
+	return nil
+}
+
+func (r *memIdempotencyRepo) Release(ctx context.Context, userID, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, userID+"/"+key)
+	return nil
+}
+
+func (r *memIdempotencyRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (r *memIdempotencyRepo) has(userID, key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.records[userID+"/"+key]
+	return ok
+}
+
+func newIdempotentRequest(userID, key, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	ctx := context.WithValue(r.Context(), session.SessionKey, &session.Session{ID: "s1", UserID: userID})
+	return r.WithContext(ctx)
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplaysFirstResponse(t *testing.T) {
+	repo := newMemIdempotencyRepo()
+	calls := 0
+	h := NewIdempotencyMiddleware(repo, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Withdrawal", "1")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"current":90}`))
+	}))
+
+	first := serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`))
+	second := serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`))
+
+	if calls != 1 {
+		t.Fatalf("handler was called %d times, want once", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != `{"current":90}` {
+		t.Errorf("replay = %d %s, want %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if second.Header().Get("X-Withdrawal") != "1" || second.Header().Get(replayedHeader) != "true" {
+		t.Errorf("replay headers = %v, want the stored ones and %s", second.Header(), replayedHeader)
+	}
+	if first.Header().Get(replayedHeader) != "" {
+		t.Errorf("first response is marked as replayed")
+	}
+
+	// Keys belong to users, the same key of another user is a new request
+	serve(h, newIdempotentRequest("u2", "k1", `{"sum":10}`))
+	if calls != 2 {
+		t.Errorf("handler was called %d times for two users, want twice", calls)
+	}
+}
+
+func TestIdempotencyRejectsKeyReuseForAnotherRequest(t *testing.T) {
+	repo := newMemIdempotencyRepo()
+	calls := 0
+	h := NewIdempotencyMiddleware(repo, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`))
+	got := serve(h, newIdempotentRequest("u1", "k1", `{"sum":1000}`))
+
+	if got.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", got.Code, http.StatusUnprocessableEntity)
+	}
+	if calls != 1 {
+		t.Errorf("handler was called %d times, want once", calls)
+	}
+}
+
+func TestIdempotencyConflictWhileInProgress(t *testing.T) {
+	repo := newMemIdempotencyRepo()
+	started, finish := make(chan struct{}), make(chan struct{})
+	h := NewIdempotencyMiddleware(repo, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`)) }()
+	<-started
+
+	got := serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`))
+	close(finish)
+	first := <-done
+
+	if got.Code != http.StatusConflict {
+		t.Errorf("status of the concurrent retry = %d, want %d", got.Code, http.StatusConflict)
+	}
+	if first.Code != http.StatusOK {
+		t.Errorf("status of the first request = %d, want %d", first.Code, http.StatusOK)
+	}
+}
+
+func TestIdempotencyReleasesKeyOfFailedRequest(t *testing.T) {
+	repo := newMemIdempotencyRepo()
+	status := http.StatusInternalServerError
+	h := NewIdempotencyMiddleware(repo, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`))
+	if repo.has("u1", "k1") {
+		t.Fatal("key of a failed request is kept")
+	}
+
+	status = http.StatusOK
+	if got := serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`)); got.Code != http.StatusOK {
+		t.Errorf("status of the retry = %d, want %d", got.Code, http.StatusOK)
+	}
+	if !repo.has("u1", "k1") {
+		t.Error("key of a successful retry isn't stored")
+	}
+}
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	repo := newMemIdempotencyRepo()
+	h := NewIdempotencyMiddleware(repo, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("recovered %v, want the handler's panic", p)
+			}
+		}()
+		serve(h, newIdempotentRequest("u1", "k1", `{"sum":10}`))
+	}()
+	if repo.has("u1", "k1") {
+		t.Error("key of a panicked request is kept")
+	}
+}
+
+func TestIdempotencyBody(t *testing.T) {
+	repo := newMemIdempotencyRepo()
+	var got []byte
+	h := NewIdempotencyMiddleware(repo, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := new(bytes.Buffer)
+		_, _ = buf.ReadFrom(r.Body)
+		got = buf.Bytes()
+	}))
+
+	limit := strings.Repeat("1", maxIdempotentBody)
+	serve(h, newIdempotentRequest("u1", "k1", limit))
+	if len(got) != maxIdempotentBody {
+		t.Errorf("handler got %d bytes of a body at the limit, want all %d", len(got), maxIdempotentBody)
+	}
+
+	got = nil
+	resp := serve(h, newIdempotentRequest("u1", "k2", limit+"1"))
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", resp.Code, http.StatusRequestEntityTooLarge)
+	}
+	if got != nil || repo.has("u1", "k2") {
+		t.Error("too large body reached the handler or reserved the key")
+	}
+}
+
+func TestIdempotencyWithoutKey(t *testing.T) {
+	repo := newMemIdempotencyRepo()
+	calls := 0
+	h := NewIdempotencyMiddleware(repo, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	serve(h, newIdempotentRequest("u1", "", `{"sum":10}`))
+	serve(h, newIdempotentRequest("u1", "", `{"sum":10}`))
+	if calls != 2 {
+		t.Errorf("handler was called %d times, want twice", calls)
+	}
+}
@@ -16,8 +16,6 @@ type loggingMiddleware struct {
 	Logger *logger.Logger
 }
 
-const requestIDKey logger.CtxLoggerKey = "requestID"
-
 func NewLoggingMiddleware(l *logger.Logger) *loggingMiddleware {
 	return &loggingMiddleware{
 		Logger: l,
@@ -34,7 +32,7 @@ func (l *loggingMiddleware) SetupTracing(next http.Handler) http.Handler {
 			w.Header().Set("trace-id", requestID)
 			w.Header().Set("X-Request-ID", requestID)
 		}
-		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		ctx := context.WithValue(r.Context(), logger.RequestIDKey, requestID)
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
@@ -43,7 +41,7 @@ func (l *loggingMiddleware) SetupTracing(next http.Handler) http.Handler {
 func (l *loggingMiddleware) SetupLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctxlogger := l.Logger.With(
-			zap.String("trace-id", requestIDFromContext(r.Context())),
+			zap.String("trace-id", logger.RequestIDFromContext(r.Context())),
 		).WithOptions(
 			zap.AddCaller(),
 			zap.AddCallerSkip(1),
@@ -76,11 +74,3 @@ func randBytesHex(n int) string {
 	rand.Read(randBytes)
 	return fmt.Sprintf("%x", randBytes)
 }
-
-func requestIDFromContext(ctx context.Context) string {
-	requestID, ok := ctx.Value(requestIDKey).(string)
-	if !ok {
-		return "-"
-	}
-	return requestID
-}
@@ -0,0 +1,161 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Number of decimal digits after the point, same as in `NUMERIC(8, 2)` columns.
+const Scale = 2
+
+// Minor units in one point.
+const unit = 100
+
+var ErrBadAmount = errors.New("money: bad amount")
+
+// Exact amount of points in minor units (hundredths), so `Amount(1050)` is 10.50.
+// Marshalled to JSON as a plain number like `10.5`, stored as a NUMERIC string.
+type Amount int64
+
+// Parses a decimal like `10`, `10.5` or `-0.25`. More than `Scale` digits after
+// the point is an error, amounts are never rounded silently.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty", ErrBadAmount)
+	}
+
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	// Trailing zeros don't matter: `1.500` is `1.5`
+	frac = strings.TrimRight(frac, "0")
+	if whole == "" && frac == "" || len(frac) > Scale || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("%w: `%s`", ErrBadAmount, s)
+	}
+
+	var units int64
+	if whole != "" {
+		w, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil || w > math.MaxInt64/unit-1 {
+			return 0, fmt.Errorf("%w: `%s` is out of range", ErrBadAmount, s)
+		}
+		units = w * unit
+	}
+	if frac != "" {
+		frac += strings.Repeat("0", Scale-len(frac))
+		f, _ := strconv.ParseInt(frac, 10, 64)
+		units += f
+	}
+
+	if neg {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// Rounds a float to the nearest minor unit. Only for values which are floats by nature.
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * unit))
+}
+
+// Formats the amount with exactly `Scale` digits after the point, like `10.50`.
+func (a Amount) String() string {
+	sign := ""
+	u := int64(a)
+	if u < 0 {
+		sign = "-"
+		u = -u
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, u/unit, Scale, u%unit)
+}
+
+// Multiplies by a factor which is an amount too (e.g. `1.25`), rounding half away from zero.
+func (a Amount) Mul(factor Amount) Amount {
+	p := int64(a) * int64(factor)
+	if p >= 0 {
+		return Amount((p + unit/2) / unit)
+	}
+	return Amount((p - unit/2) / unit)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	s := a.String()
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	return []byte(s), nil
+}
+
+// Accepts JSON numbers and numeric strings.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%w: `%s`", ErrBadAmount, s)
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+		return nil
+	case string:
+		parsed, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+		return nil
+	case []byte:
+		return a.Scan(string(v))
+	case int64:
+		*a = Amount(v * unit)
+		return nil
+	case float64:
+		*a = FromFloat(v)
+		return nil
+	default:
+		return fmt.Errorf("%w: can't scan %T", ErrBadAmount, src)
+	}
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
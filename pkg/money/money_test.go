@@ -0,0 +1,154 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{in: "10", want: 1000},
+		{in: "10.5", want: 1050},
+		{in: "10.50", want: 1050},
+		{in: "1.500", want: 150},
+		{in: "0.01", want: 1},
+		{in: ".5", want: 50},
+		{in: "5.", want: 500},
+		{in: "+3.25", want: 325},
+		{in: "-0.25", want: -25},
+		{in: "-100", want: -10000},
+		{in: " 7.1 ", want: 710},
+		{in: "", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "1.005", wantErr: true},
+		{in: "1,5", wantErr: true},
+		{in: "--1", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrBadAmount) {
+				t.Errorf("Parse(%q) error = %v, want ErrBadAmount", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{in: 0, want: "0.00"},
+		{in: 1, want: "0.01"},
+		{in: 1050, want: "10.50"},
+		{in: 10000, want: "100.00"},
+		{in: -1, want: "-0.01"},
+		{in: -25, want: "-0.25"},
+		{in: -1050, want: "-10.50"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		a, factor Amount
+		want      Amount
+	}{
+		{a: 1000, factor: 125, want: 1250},
+		{a: 1, factor: 150, want: 2},   // 0.015 rounds up
+		{a: 1, factor: 149, want: 1},   // 0.0149 rounds down
+		{a: -1, factor: 150, want: -2}, // half away from zero
+		{a: -1, factor: 149, want: -1},
+		{a: 333, factor: 110, want: 366}, // 3.663
+		{a: 1050, factor: 100, want: 1050},
+		{a: 1050, factor: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Mul(tt.factor); got != tt.want {
+			t.Errorf("%s.Mul(%s) = %s, want %s", tt.a, tt.factor, got, tt.want)
+		}
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Amount
+	}{
+		{in: 729.98, want: 72998},
+		{in: 0.005, want: 1},
+		{in: -0.005, want: -1},
+		{in: 0.1 + 0.2, want: 30},
+	}
+	for _, tt := range tests {
+		if got := FromFloat(tt.in); got != tt.want {
+			t.Errorf("FromFloat(%v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{in: 0, want: "0"},
+		{in: 1050, want: "10.5"},
+		{in: 1001, want: "10.01"},
+		{in: 10000, want: "100"},
+		{in: -25, want: "-0.25"},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.in)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("json.Marshal(%s) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{in: `10.5`, want: 1050},
+		{in: `"10.50"`, want: 1050},
+		{in: `-3`, want: -300},
+		{in: `1e2`, want: 10000},
+		{in: `null`, want: 0},
+		{in: `0.001`, wantErr: true},
+		{in: `"ten"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Amount
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("json.Unmarshal(%s) = %s, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
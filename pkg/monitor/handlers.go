@@ -0,0 +1,40 @@
+package monitor
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+)
+
+// Collects runtime stats of the app components for monitoring.
+type handler struct {
+	mu        sync.RWMutex
+	providers map[string]func() interface{}
+}
+
+func NewHandler() *handler {
+	return &handler{
+		providers: map[string]func() interface{}{},
+	}
+}
+
+// Registers a function which reports the current stats of a component under `name`.
+func (h *handler) Register(name string, stats func() interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.providers[name] = stats
+}
+
+func (h *handler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	h.mu.RLock()
+	stats := make(map[string]interface{}, len(h.providers))
+	for name, provide := range h.providers {
+		stats[name] = provide()
+	}
+	h.mu.RUnlock()
+
+	common.WriteRespJSON(w, stats)
+}
@@ -0,0 +1,114 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+)
+
+// Headers with the signature of a partner request and the time it's signed at,
+// same format as accrual webhooks, see `accrual.SignAt`.
+const (
+	PartnerSignatureHeader = "X-Partner-Signature"
+	PartnerTimestampHeader = "X-Partner-Timestamp"
+)
+
+type iOrderCanceller interface {
+	CancelOrder(ctx context.Context, orderID, source, reason string) (*Cancellation, error)
+}
+
+type cancelHandler struct {
+	service       iOrderCanceller
+	partnerSecret []byte
+}
+
+func NewCancelHandler(s iOrderCanceller, partnerSecret string) *cancelHandler {
+	return &cancelHandler{
+		service:       s,
+		partnerSecret: []byte(partnerSecret),
+	}
+}
+
+type cancelRequest struct {
+	Order  string `json:"order"`
+	Reason string `json:"reason"`
+}
+
+// Support API: the order number is in the path, the body with the reason is optional.
+func (h *cancelHandler) AdminCancel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req := new(cancelRequest)
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, req)
+	}
+	if err != nil {
+		logger.Log(r.Context()).Errorf("order/cancel: can't parse request body, %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+	req.Order = mux.Vars(r)["order"]
+
+	h.cancel(w, r, req, CancelledByAdmin)
+}
+
+// Partner API: the order number is in the body signed with the shared partner secret
+// together with the timestamp, so a signed request can't be replayed for another
+// order or once it's stale.
+func (h *cancelHandler) PartnerCancel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if len(h.partnerSecret) == 0 {
+		common.WriteMsg(w, "partner API is disabled", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		logger.Log(r.Context()).Errorf("order/cancel: failed reading body, %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	if !accrual.VerifyTimestamped(h.partnerSecret, body, r.Header.Get(PartnerTimestampHeader),
+		r.Header.Get(PartnerSignatureHeader), time.Now()) {
+		logger.Log(r.Context()).Errorf("order/cancel: bad or stale partner signature")
+		common.WriteMsg(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	req := new(cancelRequest)
+	if err := json.Unmarshal(body, req); err != nil || req.Order == "" {
+		logger.Log(r.Context()).Errorf("order/cancel: can't parse cancel request, %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	h.cancel(w, r, req, CancelledByPartner)
+}
+
+func (h *cancelHandler) cancel(w http.ResponseWriter, r *http.Request, req *cancelRequest, source string) {
+	c, err := h.service.CancelOrder(r.Context(), req.Order, source, req.Reason)
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		common.WriteMsg(w, "order not found", http.StatusNotFound)
+	case errors.Is(err, errOrderIsCancelled):
+		common.WriteMsg(w, "order is already cancelled", http.StatusConflict)
+	case errors.Is(err, errClawbackBlocked):
+		common.WriteMsg(w, "user has already spent the accrual", http.StatusConflict)
+	case err != nil:
+		common.WriteMsg(w, "can't cancel order", http.StatusInternalServerError)
+	default:
+		common.WriteRespJSON(w, c)
+	}
+}
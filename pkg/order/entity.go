@@ -1,13 +1,34 @@
 package order
 
-import "time"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
 
 type Order struct {
-	Number     string    `json:"number"`
-	UserID     string    `json:"-"`
-	Status     string    `json:"status"`
-	Accrual    float32   `json:"accrual"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	Number     string       `json:"number"`
+	UserID     string       `json:"-"`
+	Status     string       `json:"status"`
+	Accrual    money.Amount `json:"accrual"`
+	UploadedAt time.Time    `json:"uploaded_at"`
+}
+
+// Called inside the transaction which credits the accrual of a PROCESSED order,
+// so extra credits for the order are made exactly once together with it.
+type ProcessedHook func(ctx context.Context, tx *sql.Tx, o *Order) error
+
+// AccrualJob is a pending accrual lookup stored in the `accrual_jobs` table.
+// It lives until the order gets a final status, so polling survives restarts.
+type AccrualJob struct {
+	OrderID   string
+	UserID    string
+	TraceID   string // trace-id of the request which uploaded the order
+	Attempts  int
+	CreatedAt time.Time
 }
 
 const (
@@ -15,4 +36,48 @@ const (
 	NEW        = "NEW"
 	INVALID    = "INVALID"
 	PROCESSING = "PROCESSING"
+	// Accrual polling gave up on the order, it needs an operator's attention.
+	STALLED = "STALLED"
+	// The purchase was cancelled or returned, the accrual is taken back.
+	CANCELLED = "CANCELLED"
+)
+
+func IsFinal(status string) bool {
+	return status == PROCESSED || status == INVALID || status == STALLED || status == CANCELLED
+}
+
+// Who cancelled the order.
+const (
+	CancelledByAdmin   = "ADMIN"
+	CancelledByPartner = "PARTNER"
+)
+
+// What to do with the accrual of a cancelled order the user has already spent.
+type ClawbackPolicy string
+
+const (
+	ClawbackNegative ClawbackPolicy = "negative" // take it all, the balance may go below zero
+	ClawbackCap      ClawbackPolicy = "cap"      // take what's left on the balance
+	ClawbackBlock    ClawbackPolicy = "block"    // refuse to cancel the order
 )
+
+func ParseClawbackPolicy(s string) (ClawbackPolicy, error) {
+	switch p := ClawbackPolicy(s); p {
+	case ClawbackNegative, ClawbackCap, ClawbackBlock:
+		return p, nil
+	}
+	return "", fmt.Errorf("order: unknown clawback policy `%s`, must be one of %s, %s, %s",
+		s, ClawbackNegative, ClawbackCap, ClawbackBlock)
+}
+
+type Cancellation struct {
+	Order          string       `json:"order"`
+	UserID         string       `json:"-"`
+	PreviousStatus string       `json:"previous_status"`
+	Source         string       `json:"source"`
+	Reason         string       `json:"reason,omitempty"`
+	Accrual        money.Amount `json:"accrual"`     // credited for the order, bonuses and referral rewards included
+	ClawedBack     money.Amount `json:"clawed_back"` // taken back from the users
+	Unrecovered    money.Amount `json:"unrecovered"` // already spent and left to the users
+	CreatedAt      time.Time    `json:"created_at"`
+}
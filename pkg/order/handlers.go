@@ -5,12 +5,11 @@ import (
 	"errors"
 	"io"
 	"net/http"
-	"strconv"
-
-	"github.com/theplant/luhn"
+	"strings"
 
 	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ordernum"
 )
 
 type iOrderService interface {
@@ -54,20 +53,16 @@ func (h handler) AddOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate order number
-	orderNum, err := strconv.Atoi(string(body))
-	if err != nil {
-		logger.Log(r.Context()).Errorf("order/handlers: failed converting order number from string")
-		common.WriteMsg(w, "order number must be valid", http.StatusBadRequest)
-		return
-	}
-	if !luhn.Valid(orderNum) {
-		logger.Log(r.Context()).Errorf("order number `%d` validation failed", orderNum)
-		common.WriteMsg(w, "order number is not valid", http.StatusUnprocessableEntity)
+	orderNum := strings.TrimSpace(string(body))
+	var numErr *ordernum.ValidationError
+	if errors.As(ordernum.Validate(orderNum), &numErr) {
+		logger.Log(r.Context()).Errorf("order/handlers: %v", numErr)
+		common.WriteValidationMsg(w, "order number is not valid", "number", numErr.Reason)
 		return
 	}
 
 	// Add order number to system
-	_, err = h.service.AddOrder(r.Context(), strconv.Itoa(orderNum))
+	_, err = h.service.AddOrder(r.Context(), orderNum)
 	if errors.Is(err, errOrderAlreadyAdded) {
 		common.WriteMsg(w, "order is already added", http.StatusOK)
 		return
@@ -77,7 +72,7 @@ func (h handler) AddOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		logger.Log(r.Context()).Errorf("failed adding order `%d`, %v", orderNum, err)
+		logger.Log(r.Context()).Errorf("failed adding order `%s`, %v", orderNum, err)
 		common.WriteMsg(w, "can't add order", http.StatusInternalServerError)
 		return
 	}
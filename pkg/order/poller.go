@@ -0,0 +1,162 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+)
+
+const (
+	// Claimed jobs are locked for this long, it must be enough for a job to wait
+	// in the pool queue and get processed.
+	claimLease = 5 * time.Minute
+
+	releaseTimeout = 5 * time.Second
+)
+
+// Polls the accrual system for every order in the `accrual_jobs` queue until
+// the order gets a final status. Jobs are stored in the DB, so orders which
+// were pending before a restart are picked up again.
+// Jobs are claimed only when the worker pool has room for them.
+//
+// `ctx` should live as long as the app. Once it's done, polling stops: workers
+// finish their current requests and all unfinished jobs are unlocked, so they
+// are resumed right after the next start. Returns when everything is stopped.
+func (s *service) RunAccrualPolling(ctx context.Context) {
+	s.pool.Run(ctx)
+	defer s.stopAccrualPolling()
+
+	restored, err := s.repo.RestoreAccrualJobs(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("order: failed restoring accrual jobs, %v", err)
+	} else if restored > 0 {
+		logger.Log(ctx).Infof("order: restored %d accrual jobs", restored)
+	}
+
+	ticker := time.NewTicker(s.accrualClient.RetryPolicy().BaseDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			free := s.pool.Free()
+			if free == 0 {
+				continue
+			}
+			jobs, err := s.repo.ClaimAccrualJobs(ctx, free, claimLease)
+			if err != nil {
+				logger.Log(ctx).Errorf("order: failed claiming accrual jobs, %v", err)
+				continue
+			}
+			for _, j := range jobs {
+				if !s.pool.Submit(j) {
+					// Unlock it right away instead of waiting for the lease to expire
+					logger.Log(ctx).Warnf("order: accrual queue is full, job `%s` postponed", j.OrderID)
+					s.rescheduleAccrualJob(ctx, j, 0, false)
+				}
+			}
+		}
+	}
+}
+
+func (s *service) stopAccrualPolling() {
+	s.pool.Wait()
+	for _, job := range s.pool.Drain() {
+		s.releaseAccrualJob(job)
+	}
+}
+
+// Unlocks the job without counting the attempt. Uses its own context since
+// it's called when the app context is already cancelled.
+func (s *service) releaseAccrualJob(job *AccrualJob) {
+	ctx, cancel := context.WithTimeout(logger.WithTraceID(context.Background(), job.TraceID), releaseTimeout)
+	defer cancel()
+	s.rescheduleAccrualJob(ctx, job, 0, false)
+}
+
+func (s *service) processAccrualJob(appCtx context.Context, job *AccrualJob) {
+	ctx := logger.WithTraceID(appCtx, job.TraceID)
+	policy := s.accrualClient.RetryPolicy()
+
+	if policy.Exhausted(job.Attempts, time.Since(job.CreatedAt)) {
+		logger.Log(ctx).Errorf("order: can't get order `%s` accrual after %d attempts, marking it %s",
+			job.OrderID, job.Attempts, STALLED)
+		if err := s.repo.MarkOrderStalled(ctx, job.OrderID); err != nil {
+			logger.Log(ctx).Error(err)
+		}
+		return
+	}
+	pause := policy.Delay(job.Attempts)
+
+	orderAccrual, err := s.accrualClient.GetOrderAccrual(ctx, job.OrderID)
+
+	var rateLimitErr *accrual.RateLimitError
+	switch {
+	case ctx.Err() != nil:
+		// Shutting down, the job will be resumed after restart
+		s.releaseAccrualJob(job)
+		return
+	case errors.Is(err, accrual.ErrAccrualUnavailable):
+		// The circuit is open, don't count it against the order
+		logger.Log(ctx).Debugf("order: accrual is unavailable, postponing `%s`", job.OrderID)
+		s.rescheduleAccrualJob(ctx, job, pause, false)
+		return
+	case errors.As(err, &rateLimitErr):
+		// Not the order's fault, wait as long as we were asked to
+		s.rescheduleAccrualJob(ctx, job, rateLimitErr.RetryAfter, false)
+		return
+	case errors.Is(err, accrual.ErrOrderNotRegistered):
+		// The order may be registered in the accrual system later
+		logger.Log(ctx).Infof("order: `%s` is not registered in accrual yet", job.OrderID)
+		s.rescheduleAccrualJob(ctx, job, pause, true)
+		return
+	case errors.Is(err, accrual.ErrServerError), errors.Is(err, accrual.ErrMalformedResponse):
+		logger.Log(ctx).Errorf("order: accrual failed for `%s`, %v", job.OrderID, err)
+		s.rescheduleAccrualJob(ctx, job, pause, true)
+		return
+	case err != nil:
+		logger.Log(ctx).Errorf("order: failed getting order accrual, %v", err)
+		s.rescheduleAccrualJob(ctx, job, pause, true)
+		return
+	}
+
+	if err := s.updateOrderStatus(ctx, job.UserID, orderAccrual); err != nil {
+		logger.Log(ctx).Errorf("order: failed updating order status, %v", err)
+		s.rescheduleAccrualJob(ctx, job, pause, true)
+		return
+	}
+
+	// Final orders are removed from the queue by `UpdateOrderStatus`
+	if IsFinal(orderAccrual.Status) {
+		return
+	}
+	if ctx.Err() != nil {
+		s.releaseAccrualJob(job)
+		return
+	}
+	s.rescheduleAccrualJob(ctx, job, pause, true)
+}
+
+func (s *service) rescheduleAccrualJob(ctx context.Context, job *AccrualJob, delay time.Duration, countAttempt bool) {
+	if err := s.repo.RescheduleAccrualJob(ctx, job.OrderID, delay, countAttempt); err != nil {
+		logger.Log(ctx).Error(err)
+	}
+}
+
+func (s *service) AccrualStats() PoolStats {
+	return s.pool.Stats()
+}
+
+func (s *service) StalledOrdersCount(ctx context.Context) int {
+	count, err := s.repo.CountOrdersByStatus(ctx, STALLED)
+	if err != nil {
+		logger.Log(ctx).Error(err)
+		return -1
+	}
+	return count
+}
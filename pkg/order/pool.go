@@ -0,0 +1,106 @@
+package order
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type PoolStats struct {
+	Workers   int   `json:"workers"`
+	QueueSize int   `json:"queue_size"`
+	Queued    int64 `json:"queued"`
+	InFlight  int64 `json:"in_flight"`
+}
+
+// Fixed number of workers processing accrual jobs from a bounded queue.
+type workerPool struct {
+	workers  int
+	jobs     chan *AccrualJob
+	queued   int64
+	inFlight int64
+	process  func(context.Context, *AccrualJob)
+	wg       sync.WaitGroup
+}
+
+func newWorkerPool(workers, queueSize int, process func(context.Context, *AccrualJob)) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	return &workerPool{
+		workers: workers,
+		jobs:    make(chan *AccrualJob, queueSize),
+		process: process,
+	}
+}
+
+// Starts workers, they stop when `ctx` is done.
+func (p *workerPool) Run(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+}
+
+// Waits until all workers are stopped.
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *workerPool) work(ctx context.Context) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-p.jobs:
+			atomic.AddInt64(&p.queued, -1)
+			atomic.AddInt64(&p.inFlight, 1)
+			p.process(ctx, job)
+			atomic.AddInt64(&p.inFlight, -1)
+		}
+	}
+}
+
+// Queues the job without blocking, returns `false` if the queue is full.
+func (p *workerPool) Submit(job *AccrualJob) bool {
+	atomic.AddInt64(&p.queued, 1)
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		atomic.AddInt64(&p.queued, -1)
+		return false
+	}
+}
+
+// Takes all jobs which are still queued, used on shutdown after workers are stopped.
+func (p *workerPool) Drain() []*AccrualJob {
+	jobs := []*AccrualJob{}
+	for {
+		select {
+		case job := <-p.jobs:
+			atomic.AddInt64(&p.queued, -1)
+			jobs = append(jobs, job)
+		default:
+			return jobs
+		}
+	}
+}
+
+// How many jobs can be queued right now.
+func (p *workerPool) Free() int {
+	return cap(p.jobs) - len(p.jobs)
+}
+
+func (p *workerPool) Stats() PoolStats {
+	return PoolStats{
+		Workers:   p.workers,
+		QueueSize: cap(p.jobs),
+		Queued:    atomic.LoadInt64(&p.queued),
+		InFlight:  atomic.LoadInt64(&p.inFlight),
+	}
+}
@@ -0,0 +1,98 @@
+package order
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolSubmitFullQueue(t *testing.T) {
+	p := newWorkerPool(1, 2, func(context.Context, *AccrualJob) {})
+
+	// Workers aren't running, so the queue fills up
+	for i, want := range []bool{true, true, false} {
+		if got := p.Submit(&AccrualJob{OrderID: "1"}); got != want {
+			t.Errorf("Submit #%d = %v, want %v", i, got, want)
+		}
+	}
+	stats := p.Stats()
+	if stats.Queued != 2 || stats.QueueSize != 2 || p.Free() != 0 {
+		t.Errorf("stats = %+v, free %d, want 2 of 2 queued", stats, p.Free())
+	}
+}
+
+func TestWorkerPoolProcessesJobs(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		processed []string
+		wg        sync.WaitGroup
+	)
+	p := newWorkerPool(3, 10, func(ctx context.Context, job *AccrualJob) {
+		mu.Lock()
+		processed = append(processed, job.OrderID)
+		mu.Unlock()
+		wg.Done()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Run(ctx)
+
+	orders := []string{"1", "2", "3", "4", "5"}
+	wg.Add(len(orders))
+	for _, o := range orders {
+		if !p.Submit(&AccrualJob{OrderID: o}) {
+			t.Fatalf("Submit(%s) = false, the queue isn't full", o)
+		}
+	}
+	wg.Wait()
+	cancel()
+	p.Wait()
+
+	if len(processed) != len(orders) {
+		t.Errorf("processed %v, want all of %v", processed, orders)
+	}
+	if stats := p.Stats(); stats.Queued != 0 || stats.InFlight != 0 {
+		t.Errorf("stats after processing = %+v, want nothing queued or in flight", stats)
+	}
+}
+
+func TestWorkerPoolDrain(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{}, 1)
+	p := newWorkerPool(1, 3, func(ctx context.Context, job *AccrualJob) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-block
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Run(ctx)
+
+	// The only worker is busy with the first job, the rest stay queued
+	p.Submit(&AccrualJob{OrderID: "1"})
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the worker didn't take the first job")
+	}
+	p.Submit(&AccrualJob{OrderID: "2"})
+	p.Submit(&AccrualJob{OrderID: "3"})
+
+	// The worker may pick one more queued job once it's done with the current one,
+	// so the queue is drained while it's still busy
+	cancel()
+	drained := p.Drain()
+	close(block)
+	p.Wait()
+
+	if len(drained) != 2 || drained[0].OrderID != "2" || drained[1].OrderID != "3" {
+		t.Errorf("Drain() = %d jobs, want the queued orders 2 and 3", len(drained))
+	}
+	if stats := p.Stats(); stats.Queued != 0 {
+		t.Errorf("queued after drain = %d, want 0", stats.Queued)
+	}
+	if again := p.Drain(); len(again) != 0 {
+		t.Errorf("second Drain() = %d jobs, want none", len(again))
+	}
+}
@@ -3,11 +3,18 @@ package order
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
 )
 
 type repo struct {
-	db *sql.DB
+	db             *sql.DB
+	processedHooks []ProcessedHook
 }
 
 func NewRepo(db *sql.DB) *repo {
@@ -16,6 +23,13 @@ func NewRepo(db *sql.DB) *repo {
 	}
 }
 
+// Registers the hook called for every PROCESSED order, the accrual may be zero.
+// Hooks are called in the order they were added. Not safe for concurrent use,
+// all hooks must be added on startup.
+func (r *repo) AddProcessedHook(h ProcessedHook) {
+	r.processedHooks = append(r.processedHooks, h)
+}
+
 func (r *repo) GetOrders(ctx context.Context, userID string) ([]*Order, error) {
 	q := `SELECT id, user_id, accrual, status, uploaded_at FROM orders
 	      WHERE user_id=$1 ORDER BY uploaded_at DESC`
@@ -39,34 +53,82 @@ func (r *repo) GetOrders(ctx context.Context, userID string) ([]*Order, error) {
 	return orders, nil
 }
 
+// Adds the order and enqueues the accrual lookup for it in one transaction.
 func (r *repo) AddOrder(ctx context.Context, order *Order) error {
-	_, err := r.db.Exec("INSERT INTO orders(id, user_id, accrual, status) VALUES($1, $2, $3, $4)",
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("order/repo: failed init add order transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO orders(id, user_id, accrual, status) VALUES($1, $2, $3, $4)",
 		order.Number, order.UserID, order.Accrual, order.Status)
 	if err != nil {
 		return fmt.Errorf("order/repo: failed inserting order, %w", err)
 	}
-	return nil
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO accrual_jobs(order_id, user_id, trace_id) VALUES($1, $2, $3)",
+		order.Number, order.UserID, logger.RequestIDFromContext(ctx))
+	if err != nil {
+		return fmt.Errorf("order/repo: failed inserting accrual job, %w", err)
+	}
+
+	return tx.Commit()
 }
 
-func (r *repo) UpdateOrderStatus(userID, orderID, newStatus string, accrual float32) error {
-	tx, err := r.db.Begin()
+// Updates the status of a pending order and credits the accrual for PROCESSED ones.
+// Final orders are never changed, so the update is safe to repeat: it returns
+// `ErrOrderIsFinal` and the accrual is credited only once.
+// STALLED orders can only get a final status (e.g. pushed by the accrual system).
+func (r *repo) UpdateOrderStatus(ctx context.Context, userID, orderID, newStatus string, accrual money.Amount) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("order: failed init update order status transaction, %w", err)
 	}
 	defer tx.Rollback()
 
-	q := `UPDATE orders SET status=$1, accrual=$2 WHERE id=$3`
-	_, err = tx.Exec(q, newStatus, accrual, orderID)
+	q := `UPDATE orders SET status=$1, accrual=$2
+	      WHERE id=$3 AND (status IN ('NEW', 'PROCESSING') OR status = 'STALLED' AND $4)`
+	res, err := tx.ExecContext(ctx, q, newStatus, accrual, orderID, IsFinal(newStatus))
 	if err != nil {
 		return fmt.Errorf("order: failed updating order status, %w", err)
 	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("order: failed updating order status, %w", err)
+	}
+	if updated == 0 {
+		return ErrOrderIsFinal
+	}
+
+	if newStatus == PROCESSED && accrual > 0 {
+		err = ledger.Post(ctx, tx, &ledger.Posting{
+			UserID:  userID,
+			Kind:    ledger.ACCRUAL,
+			Debit:   ledger.AccountAccrual,
+			Credit:  ledger.UserAccount(userID),
+			Amount:  accrual,
+			OrderID: orderID,
+		})
+		if err != nil {
+			return fmt.Errorf("order: failed crediting accrual, %w", err)
+		}
+	}
 
 	if newStatus == PROCESSED {
-		q := `UPDATE users SET balance = balance + $1 WHERE id = $2 RETURNING balance`
-		var newBalance float32
-		err = tx.QueryRow(q, accrual, userID).Scan(&newBalance)
+		processed := &Order{Number: orderID, UserID: userID, Status: newStatus, Accrual: accrual}
+		for _, hook := range r.processedHooks {
+			if err = hook(ctx, tx, processed); err != nil {
+				return fmt.Errorf("order: processed hook failed for `%s`, %w", orderID, err)
+			}
+		}
+	}
+
+	// Nothing to poll for final orders
+	if IsFinal(newStatus) {
+		_, err = tx.ExecContext(ctx, `DELETE FROM accrual_jobs WHERE order_id = $1`, orderID)
 		if err != nil {
-			return fmt.Errorf("order: failed updating user balance, %w", err)
+			return fmt.Errorf("order: failed deleting accrual job, %w", err)
 		}
 	}
 
@@ -83,3 +145,232 @@ func (r *repo) GetOrder(ctx context.Context, orderID string) (*Order, error) {
 	}
 	return o, nil
 }
+
+// Enqueues accrual jobs for all orders which are not final yet and have no job.
+// Used on startup to pick up orders uploaded before the queue existed.
+func (r *repo) RestoreAccrualJobs(ctx context.Context) (int64, error) {
+	q := `INSERT INTO accrual_jobs(order_id, user_id)
+	      SELECT id, user_id FROM orders WHERE status IN ('NEW', 'PROCESSING')
+	      ON CONFLICT (order_id) DO NOTHING`
+	res, err := r.db.ExecContext(ctx, q)
+	if err != nil {
+		return 0, fmt.Errorf("order/repo: failed restoring accrual jobs, %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Locks up to `limit` due jobs for `lease` so no other poller takes them meanwhile.
+// The lease expires by itself if the poller dies before rescheduling the job.
+func (r *repo) ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]*AccrualJob, error) {
+	q := `UPDATE accrual_jobs SET locked_until = NOW() + make_interval(secs => $2)
+	      WHERE order_id IN (
+	        SELECT order_id FROM accrual_jobs
+	        WHERE next_run_at <= NOW() AND (locked_until IS NULL OR locked_until < NOW())
+	        ORDER BY next_run_at LIMIT $1
+	        FOR UPDATE SKIP LOCKED
+	      )
+	      RETURNING order_id, user_id, trace_id, attempts, created_at`
+	rows, err := r.db.QueryContext(ctx, q, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("order/repo: failed claiming accrual jobs, %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	jobs := []*AccrualJob{}
+	for rows.Next() {
+		j := new(AccrualJob)
+		if err := rows.Scan(&j.OrderID, &j.UserID, &j.TraceID, &j.Attempts, &j.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan accrual job row failed: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Unlocks the job and schedules its next run after `delay`.
+// Attempts which failed not because of the order itself (e.g. rate limiting) shouldn't be counted.
+func (r *repo) RescheduleAccrualJob(ctx context.Context, orderID string, delay time.Duration, countAttempt bool) error {
+	q := `UPDATE accrual_jobs
+	      SET attempts = attempts + $3, next_run_at = NOW() + make_interval(secs => $2), locked_until = NULL
+	      WHERE order_id = $1`
+	inc := 0
+	if countAttempt {
+		inc = 1
+	}
+	_, err := r.db.ExecContext(ctx, q, orderID, delay.Seconds(), inc)
+	if err != nil {
+		return fmt.Errorf("order/repo: failed rescheduling accrual job for `%s`, %w", orderID, err)
+	}
+	return nil
+}
+
+// Moves a pending order to the terminal STALLED status and drops its accrual job.
+func (r *repo) MarkOrderStalled(ctx context.Context, orderID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("order/repo: failed init mark stalled transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	q := `UPDATE orders SET status = $1 WHERE id = $2 AND status IN ('NEW', 'PROCESSING')`
+	if _, err = tx.ExecContext(ctx, q, STALLED, orderID); err != nil {
+		return fmt.Errorf("order/repo: failed marking order `%s` stalled, %w", orderID, err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM accrual_jobs WHERE order_id = $1`, orderID); err != nil {
+		return fmt.Errorf("order/repo: failed deleting accrual job for `%s`, %w", orderID, err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *repo) CountOrdersByStatus(ctx context.Context, status string) (int, error) {
+	count := 0
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders WHERE status = $1`, status).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("order/repo: failed counting `%s` orders, %w", status, err)
+	}
+	return count, nil
+}
+
+// Moves the order to CANCELLED and takes everything credited for it back according
+// to `policy`, from the order's user and from users rewarded for it. Fails with
+// `errOrderNotFound` for unknown orders, with `errOrderIsCancelled` if it's already
+// cancelled and with `errClawbackBlocked` if somebody has spent the points and
+// the policy is `ClawbackBlock`.
+func (r *repo) CancelOrder(ctx context.Context, c *Cancellation, policy ClawbackPolicy) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("order/repo: failed init cancel order transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	// The lock keeps accrual updates away until the order is cancelled
+	q := `SELECT user_id, status FROM orders WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, q, c.Order).Scan(&c.UserID, &c.PreviousStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("order/repo: `%s`, %w", c.Order, errOrderNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("order/repo: failed getting order `%s`, %w", c.Order, err)
+	}
+	if c.PreviousStatus == CANCELLED {
+		return fmt.Errorf("order/repo: `%s`, %w", c.Order, errOrderIsCancelled)
+	}
+
+	// Everything credited for the order: the accrual, bonuses and corrections.
+	// Other users may have got rewards for the order too, e.g. the referrer.
+	credits, err := creditedForOrder(ctx, tx, c.Order)
+	if err != nil {
+		return err
+	}
+	var credited money.Amount
+	for _, cr := range credits {
+		credited += cr.amount
+		clawedBack, err := clawback(ctx, tx, c.Order, cr.userID, cr.amount, policy)
+		if err != nil {
+			return err
+		}
+		c.ClawedBack += clawedBack
+	}
+	c.Accrual = credited
+	c.Unrecovered = credited - c.ClawedBack
+
+	_, err = tx.ExecContext(ctx, `UPDATE orders SET status = $1 WHERE id = $2`, CANCELLED, c.Order)
+	if err != nil {
+		return fmt.Errorf("order/repo: failed cancelling order `%s`, %w", c.Order, err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM accrual_jobs WHERE order_id = $1`, c.Order)
+	if err != nil {
+		return fmt.Errorf("order/repo: failed deleting accrual job for `%s`, %w", c.Order, err)
+	}
+
+	q = `INSERT INTO order_cancellations(order_id, user_id, previous_status, source, reason,
+	       accrual, clawed_back, unrecovered)
+	     VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+	     RETURNING created_at`
+	err = tx.QueryRowContext(ctx, q, c.Order, c.UserID, c.PreviousStatus, c.Source, c.Reason,
+		c.Accrual, c.ClawedBack, c.Unrecovered).Scan(&c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("order/repo: failed inserting to `order_cancellations` table, %w", err)
+	}
+
+	return tx.Commit()
+}
+
+type orderCredit struct {
+	userID string
+	amount money.Amount
+}
+
+// Users who got points for the order with the net amount, the order's user goes first.
+func creditedForOrder(ctx context.Context, tx *sql.Tx, orderID string) ([]*orderCredit, error) {
+	q := `SELECT l.user_id, SUM(CASE WHEN l.credit_account = $2::text || l.user_id THEN l.amount ELSE -l.amount END)
+	      FROM ledger l JOIN orders o ON o.id = l.order_id
+	      WHERE l.order_id = $1 AND l.kind IN ($3, $4, $5)
+	        AND (l.credit_account = $2::text || l.user_id OR l.debit_account = $2::text || l.user_id)
+	      GROUP BY l.user_id, o.user_id
+	      HAVING SUM(CASE WHEN l.credit_account = $2::text || l.user_id THEN l.amount ELSE -l.amount END) > 0
+	      ORDER BY l.user_id = o.user_id DESC, l.user_id`
+	rows, err := tx.QueryContext(ctx, q, orderID, ledger.UserAccount(""),
+		ledger.ACCRUAL, ledger.BONUS, ledger.ADJUSTMENT)
+	if err != nil {
+		return nil, fmt.Errorf("order/repo: failed getting points credited for `%s`, %w", orderID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	credits := []*orderCredit{}
+	for rows.Next() {
+		cr := new(orderCredit)
+		if err := rows.Scan(&cr.userID, &cr.amount); err != nil {
+			return nil, fmt.Errorf("scan order credit row failed: %w", err)
+		}
+		credits = append(credits, cr)
+	}
+	return credits, rows.Err()
+}
+
+// Debits the points credited for the order from the user, returns how much is taken back.
+func clawback(ctx context.Context, tx *sql.Tx, orderID, userID string, credited money.Amount,
+	policy ClawbackPolicy) (money.Amount, error) {
+	if credited <= 0 {
+		return 0, nil
+	}
+
+	amount := credited
+	if policy == ClawbackCap {
+		var balance money.Amount
+		err := tx.QueryRowContext(ctx, `SELECT balance FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&balance)
+		if err != nil {
+			return 0, fmt.Errorf("order/repo: failed getting balance of user `%s`, %w", userID, err)
+		}
+		if balance < amount {
+			amount = balance
+		}
+		if amount <= 0 {
+			return 0, nil
+		}
+	}
+
+	err := ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:         userID,
+		Kind:           ledger.CLAWBACK,
+		Debit:          ledger.UserAccount(userID),
+		Credit:         ledger.AccountAccrual,
+		Amount:         amount,
+		OrderID:        orderID,
+		AllowOverdraft: policy == ClawbackNegative,
+	})
+	if errors.Is(err, ledger.ErrInsufficientFunds) {
+		return 0, fmt.Errorf("order/repo: %v, %w", err, errClawbackBlocked)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("order/repo: failed taking back accrual of `%s`, %w", orderID, err)
+	}
+	return amount, nil
+}
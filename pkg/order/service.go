@@ -4,10 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
 )
 
@@ -15,30 +17,47 @@ type iOrderRepo interface {
 	GetOrders(ctx context.Context, userID string) ([]*Order, error)
 	GetOrder(ctx context.Context, orderID string) (*Order, error)
 	AddOrder(ctx context.Context, o *Order) error
-	UpdateOrderStatus(userID, orderID, newStatus string, accrual float32) error
+	UpdateOrderStatus(ctx context.Context, userID, orderID, newStatus string, accrual money.Amount) error
+	RestoreAccrualJobs(ctx context.Context) (int64, error)
+	ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]*AccrualJob, error)
+	RescheduleAccrualJob(ctx context.Context, orderID string, delay time.Duration, countAttempt bool) error
+	MarkOrderStalled(ctx context.Context, orderID string) error
+	CountOrdersByStatus(ctx context.Context, status string) (int, error)
+	CancelOrder(ctx context.Context, c *Cancellation, policy ClawbackPolicy) error
 }
 
 type iAccrualClient interface {
 	GetOrderAccrual(ctx context.Context, orderNum string) (*accrual.OrderAccrual, error)
-	MaxAttempts() int
-	Interval() time.Duration
+	RetryPolicy() accrual.RetryPolicy
 }
 
 type service struct {
 	repo          iOrderRepo
 	accrualClient iAccrualClient
+	pool          *workerPool
+	clawback      ClawbackPolicy
 }
 
-func NewService(r iOrderRepo, accSys iAccrualClient) *service {
-	return &service{
+func NewService(r iOrderRepo, accSys iAccrualClient, workers, queueSize int, clawback ClawbackPolicy) *service {
+	s := &service{
 		repo:          r,
 		accrualClient: accSys,
+		clawback:      clawback,
 	}
+	s.pool = newWorkerPool(workers, queueSize, s.processAccrualJob)
+	return s
 }
 
+// Returned by `UpdateOrderStatus` for orders which already have a final status.
+var ErrOrderIsFinal = errors.New("order status is final")
+
 var (
 	errOrderAlreadyAdded   = errors.New("order already added")
 	errOrderExistsForOther = errors.New("order already exists for the other user")
+	errOrderNotFound       = errors.New("order not found")
+	errBadAccrualStatus    = errors.New("unknown accrual status")
+	errOrderIsCancelled    = errors.New("order is already cancelled")
+	errClawbackBlocked     = errors.New("accrual is already spent")
 )
 
 func (s *service) AddOrder(ctx context.Context, orderNum string) (*Order, error) {
@@ -79,48 +98,58 @@ func (s *service) AddOrder(ctx context.Context, orderNum string) (*Order, error)
 		return nil, err
 	}
 
-	go s.updateOrderStatus(ctx, userID, orderNum)
-
 	return newOrder, nil
 }
 
-func (s *service) updateOrderStatus(ctx context.Context, userID, orderNum string) {
-	done := make(chan struct{})
-	attempts := 0
-	maxAttempts := s.accrualClient.MaxAttempts()
-	pause := s.accrualClient.Interval()
-
-	for {
-		select {
-		case <-done:
-			return
-		default:
-			attempts++
-			if attempts >= maxAttempts {
-				logger.Log(ctx).Errorf("order: can't get order accrual, max attempts exceeded")
-				done <- struct{}{}
-			}
-
-			orderAccrual, err := s.accrualClient.GetOrderAccrual(ctx, orderNum)
-			if err != nil {
-				logger.Log(ctx).Errorf("order: failed getting order accrual, %v", err)
-				time.Sleep(pause)
-				continue // try once again
-			}
-
-			if err := s.repo.UpdateOrderStatus(userID, orderNum, orderAccrual.Status, orderAccrual.Accrual); err != nil {
-				logger.Log(ctx).Errorf("order: failed updating order status, %w", err)
-				time.Sleep(pause)
-				continue // try once again
-			}
-
-			if orderAccrual.Status == INVALID || orderAccrual.Status == PROCESSED {
-				done <- struct{}{}
-			}
-
-			time.Sleep(pause)
-		}
+// Applies an accrual update pushed by the accrual system. Goes through the same
+// path as polling, so repeated and late updates are ignored.
+func (s *service) ApplyAccrual(ctx context.Context, orderAccrual *accrual.OrderAccrual) error {
+	switch orderAccrual.Status {
+	case accrual.REGISTERED, accrual.PROCESSING, accrual.PROCESSED, accrual.INVALID:
+	default:
+		return fmt.Errorf("order: status `%s`, %w", orderAccrual.Status, errBadAccrualStatus)
+	}
+	if err := orderAccrual.ValidateAccrual(); err != nil {
+		return fmt.Errorf("order: update of `%s`, %w", orderAccrual.Order, err)
+	}
+
+	ord, err := s.repo.GetOrder(ctx, orderAccrual.Order)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("order: `%s`, %w", orderAccrual.Order, errOrderNotFound)
+	}
+	if err != nil {
+		logger.Log(ctx).Errorf("order: failed getting order, %v", err)
+		return err
+	}
+
+	return s.updateOrderStatus(ctx, ord.UserID, orderAccrual)
+}
+
+// Saves the accrual system answer about the order, a no-op for orders which are already final.
+func (s *service) updateOrderStatus(ctx context.Context, userID string, orderAccrual *accrual.OrderAccrual) error {
+	// Registered orders are new for us
+	if orderAccrual.Status == accrual.REGISTERED {
+		return nil
+	}
+
+	err := s.repo.UpdateOrderStatus(ctx, userID, orderAccrual.Order, orderAccrual.Status, orderAccrual.Accrual)
+	if errors.Is(err, ErrOrderIsFinal) {
+		logger.Log(ctx).Infof("order: `%s` is already final, update to %s skipped", orderAccrual.Order, orderAccrual.Status)
+		return nil
+	}
+	return err
+}
+
+// Cancels the order on behalf of `source` and takes its accrual back.
+func (s *service) CancelOrder(ctx context.Context, orderID, source, reason string) (*Cancellation, error) {
+	c := &Cancellation{Order: orderID, Source: source, Reason: reason}
+	if err := s.repo.CancelOrder(ctx, c, s.clawback); err != nil {
+		logger.Log(ctx).Errorf("order: cancel failed, %v", err)
+		return nil, err
 	}
+	logger.Log(ctx).Infof("order: `%s` cancelled by %s, clawed back %s, unrecovered %s",
+		c.Order, c.Source, c.ClawedBack, c.Unrecovered)
+	return c, nil
 }
 
 func (s *service) GetUserOrders(ctx context.Context) (orders []*Order, err error) {
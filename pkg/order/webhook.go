@@ -0,0 +1,85 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+)
+
+const maxWebhookBodySize = 64 << 10
+
+type iAccrualUpdater interface {
+	ApplyAccrual(ctx context.Context, orderAccrual *accrual.OrderAccrual) error
+}
+
+type webhookHandler struct {
+	service iAccrualUpdater
+	secret  []byte
+}
+
+func NewWebhookHandler(s iAccrualUpdater, secret string) *webhookHandler {
+	return &webhookHandler{
+		service: s,
+		secret:  []byte(secret),
+	}
+}
+
+// Accrual updates pushed by the accrual provider, signed with the shared secret
+// together with a timestamp, so stale updates are rejected as replays.
+func (h *webhookHandler) AccrualUpdate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if len(h.secret) == 0 {
+		common.WriteMsg(w, "accrual webhook is disabled", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		logger.Log(r.Context()).Errorf("order/webhook: failed reading body, %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	if !accrual.VerifyTimestamped(h.secret, body, r.Header.Get(accrual.TimestampHeader),
+		r.Header.Get(accrual.SignatureHeader), time.Now()) {
+		logger.Log(r.Context()).Errorf("order/webhook: bad or stale signature")
+		common.WriteMsg(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	orderAccrual := new(accrual.OrderAccrual)
+	if err := json.Unmarshal(body, orderAccrual); err != nil || orderAccrual.Order == "" {
+		logger.Log(r.Context()).Errorf("order/webhook: can't parse accrual update, %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.ApplyAccrual(r.Context(), orderAccrual)
+	if errors.Is(err, errBadAccrualStatus) {
+		common.WriteMsg(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, accrual.ErrBadAccrual) {
+		common.WriteMsg(w, "accrual must be non-negative and only set for PROCESSED orders", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errOrderNotFound) {
+		common.WriteMsg(w, "order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Log(r.Context()).Errorf("order/webhook: failed applying accrual for `%s`, %v", orderAccrual.Order, err)
+		common.WriteMsg(w, "can't apply accrual", http.StatusInternalServerError)
+		return
+	}
+
+	common.WriteMsg(w, "accrual update accepted", http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package ordernum
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Orders are stored in VARCHAR(128) columns.
+const MaxLength = 128
+
+const (
+	ReasonEmpty     = "empty"
+	ReasonTooLong   = "too long"
+	ReasonNotDigits = "not digits"
+	ReasonChecksum  = "checksum"
+)
+
+var ErrInvalid = errors.New("invalid order number")
+
+// Describes why the order number is invalid, matches `ErrInvalid` with `errors.Is`.
+type ValidationError struct {
+	Number string `json:"number"`
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v `%s`: %s", ErrInvalid, e.Number, e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// Checks the order number is a string of digits with a valid Luhn checksum.
+// Works on the string itself, so numbers of any length are fine.
+func Validate(number string) error {
+	switch {
+	case number == "":
+		return &ValidationError{Number: number, Reason: ReasonEmpty}
+	case len(number) > MaxLength:
+		return &ValidationError{Number: number[:MaxLength] + "...", Reason: ReasonTooLong}
+	}
+
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			return &ValidationError{Number: number, Reason: ReasonNotDigits}
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	if sum%10 != 0 {
+		return &ValidationError{Number: number, Reason: ReasonChecksum}
+	}
+	return nil
+}
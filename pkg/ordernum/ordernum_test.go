@@ -0,0 +1,42 @@
+package ordernum
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		number string
+		reason string // empty for valid numbers
+	}{
+		{number: "0"},
+		{number: "18"},
+		{number: "79927398713"},
+		{number: "4561261212345467"},
+		{number: "12345678903"},
+		{number: strings.Repeat("0", MaxLength)},
+		{number: "", reason: ReasonEmpty},
+		{number: strings.Repeat("0", MaxLength+1), reason: ReasonTooLong},
+		{number: "7992739871a", reason: ReasonNotDigits},
+		{number: "-18", reason: ReasonNotDigits},
+		{number: " 18", reason: ReasonNotDigits},
+		{number: "79927398710", reason: ReasonChecksum},
+		{number: "12345678904", reason: ReasonChecksum},
+		{number: "81", reason: ReasonChecksum},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.number)
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("Validate(%q) = %v, want nil", tt.number, err)
+			}
+			continue
+		}
+		var vErr *ValidationError
+		if !errors.As(err, &vErr) || !errors.Is(err, ErrInvalid) || vErr.Reason != tt.reason {
+			t.Errorf("Validate(%q) = %v, want reason %q", tt.number, err, tt.reason)
+		}
+	}
+}
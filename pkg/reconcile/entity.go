@@ -0,0 +1,41 @@
+package reconcile
+
+import (
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Kinds of drift between our orders and the accrual system.
+const (
+	AmountMismatch  = "AMOUNT"  // both PROCESSED, accruals differ
+	StatusMismatch  = "STATUS"  // final statuses differ
+	MissingUpstream = "MISSING" // the accrual system doesn't know the order
+)
+
+type Order struct {
+	Number     string
+	UserID     string
+	Status     string
+	Accrual    money.Amount
+	UploadedAt time.Time
+}
+
+type Mismatch struct {
+	Kind          string       `json:"kind"`
+	Order         string       `json:"order"`
+	UserID        string       `json:"user_id"`
+	LocalStatus   string       `json:"local_status"`
+	LocalAccrual  money.Amount `json:"local_accrual"`
+	RemoteStatus  string       `json:"remote_status,omitempty"`
+	RemoteAccrual money.Amount `json:"remote_accrual"`
+	Corrected     bool         `json:"corrected"`
+}
+
+type Report struct {
+	From       time.Time   `json:"from"`
+	To         time.Time   `json:"to"`
+	Checked    int         `json:"checked"`
+	Failed     int         `json:"failed"` // orders which couldn't be fetched from the accrual system
+	Mismatches []*Mismatch `json:"mismatches"`
+}
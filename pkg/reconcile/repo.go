@@ -0,0 +1,96 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+)
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+// Cancelled orders are skipped: they differ from the accrual system on purpose.
+func (r *repo) GetOrders(ctx context.Context, from, to time.Time) ([]*Order, error) {
+	q := `SELECT id, user_id, status, accrual, uploaded_at FROM orders
+	      WHERE uploaded_at >= $1 AND uploaded_at < $2 AND status <> 'CANCELLED'
+	      ORDER BY uploaded_at`
+	rows, err := r.db.QueryContext(ctx, q, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("reconcile/repo: failed getting orders, %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	orders := []*Order{}
+	for rows.Next() {
+		o := new(Order)
+		if err := rows.Scan(&o.Number, &o.UserID, &o.Status, &o.Accrual, &o.UploadedAt); err != nil {
+			return nil, fmt.Errorf("scan order row failed: %w", err)
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// Sets the PROCESSED or INVALID order to what the accrual system reports and adjusts
+// the user balance by the difference between the new and the already credited accrual.
+// Does nothing if the order was changed since it was read.
+func (r *repo) ApplyCorrection(ctx context.Context, m *Mismatch) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("reconcile/repo: failed init correction transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	q := `UPDATE orders SET status = $1, accrual = $2
+	      WHERE id = $3 AND status = $4 AND accrual = $5`
+	res, err := tx.ExecContext(ctx, q, m.RemoteStatus, m.RemoteAccrual, m.Order, m.LocalStatus, m.LocalAccrual)
+	if err != nil {
+		return false, fmt.Errorf("reconcile/repo: failed correcting order `%s`, %w", m.Order, err)
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+		return false, err
+	}
+
+	adjustment := credited(m.RemoteStatus, m.RemoteAccrual) - credited(m.LocalStatus, m.LocalAccrual)
+	if adjustment != 0 {
+		p := &ledger.Posting{
+			UserID:  m.UserID,
+			Kind:    ledger.ADJUSTMENT,
+			Debit:   ledger.AccountAdjustments,
+			Credit:  ledger.UserAccount(m.UserID),
+			Amount:  adjustment,
+			OrderID: m.Order,
+			// Corrections are applied even if the points are already spent
+			AllowOverdraft: true,
+		}
+		if adjustment < 0 {
+			p.Debit, p.Credit, p.Amount = p.Credit, p.Debit, -adjustment
+		}
+		if err = ledger.Post(ctx, tx, p); err != nil {
+			return false, fmt.Errorf("reconcile/repo: failed adjusting balance of user `%s`, %w", m.UserID, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM accrual_jobs WHERE order_id = $1`, m.Order)
+	if err != nil {
+		return false, fmt.Errorf("reconcile/repo: failed deleting accrual job for `%s`, %w", m.Order, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("reconcile/repo: failed committing correction, %w", err)
+	}
+	return true, nil
+}
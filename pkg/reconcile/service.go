@@ -0,0 +1,186 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/order"
+)
+
+const maxRateLimitRetries = 3
+
+type iReconcileRepo interface {
+	GetOrders(ctx context.Context, from, to time.Time) ([]*Order, error)
+	ApplyCorrection(ctx context.Context, m *Mismatch) (bool, error)
+}
+
+type iAccrualClient interface {
+	GetOrderAccrual(ctx context.Context, orderNum string) (*accrual.OrderAccrual, error)
+}
+
+// The order repo with processed hooks registered, pending orders are corrected through it.
+type iOrderUpdater interface {
+	UpdateOrderStatus(ctx context.Context, userID, orderID, newStatus string, accrual money.Amount) error
+}
+
+type service struct {
+	repo          iReconcileRepo
+	accrualClient iAccrualClient
+	orders        iOrderUpdater
+}
+
+func NewService(r iReconcileRepo, accSys iAccrualClient, orders iOrderUpdater) *service {
+	return &service{
+		repo:          r,
+		accrualClient: accSys,
+		orders:        orders,
+	}
+}
+
+// Compares orders uploaded in [from, to) with the accrual system.
+// With `apply` mismatches with a final upstream status are corrected,
+// the user balance is adjusted by the accrual difference.
+func (s *service) Reconcile(ctx context.Context, from, to time.Time, apply bool) (*Report, error) {
+	orders, err := s.repo.GetOrders(ctx, from, to)
+	if err != nil {
+		logger.Log(ctx).Errorf("reconcile: can't get orders, %v", err)
+		return nil, err
+	}
+
+	report := &Report{From: from, To: to, Mismatches: []*Mismatch{}}
+	for _, o := range orders {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		remote, err := s.getOrderAccrual(ctx, o.Number)
+		if err != nil && !errors.Is(err, accrual.ErrOrderNotRegistered) {
+			logger.Log(ctx).Errorf("reconcile: can't get accrual for `%s`, %v", o.Number, err)
+			report.Failed++
+			continue
+		}
+		report.Checked++
+
+		m := compare(o, remote)
+		if m == nil {
+			continue
+		}
+		report.Mismatches = append(report.Mismatches, m)
+
+		// Only final upstream results are worth copying
+		if apply && m.Kind != MissingUpstream && isFinal(m.RemoteStatus) {
+			m.Corrected, err = s.correct(ctx, m)
+			if err != nil {
+				logger.Log(ctx).Errorf("reconcile: can't correct `%s`, %v", o.Number, err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Reconciles orders uploaded during the last `window` every `interval` until `ctx` is done.
+func (s *service) RunScheduled(ctx context.Context, interval, window time.Duration, apply bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			to := time.Now()
+			report, err := s.Reconcile(ctx, to.Add(-window), to, apply)
+			if report == nil {
+				logger.Log(ctx).Errorf("reconcile: scheduled run failed, %v", err)
+				continue
+			}
+			// An interrupted run still reports what it has checked and corrected
+			if err != nil {
+				logger.Log(ctx).Errorf("reconcile: scheduled run interrupted, %v", err)
+			}
+			logger.Log(ctx).Infof("reconcile: checked %d orders, %d failed, %d mismatches",
+				report.Checked, report.Failed, len(report.Mismatches))
+			for _, m := range report.Mismatches {
+				logger.Log(ctx).Warnf("reconcile: %s mismatch for `%s`: local %s %s, remote %s %s, corrected: %t",
+					m.Kind, m.Order, m.LocalStatus, m.LocalAccrual, m.RemoteStatus, m.RemoteAccrual, m.Corrected)
+			}
+		}
+	}
+}
+
+// Pending orders get the upstream result the same way as from polling, so PROCESSED
+// ones get their tier, campaign and referral bonuses. Final orders are adjusted.
+func (s *service) correct(ctx context.Context, m *Mismatch) (bool, error) {
+	if isPending(m.LocalStatus) {
+		err := s.orders.UpdateOrderStatus(ctx, m.UserID, m.Order, m.RemoteStatus, m.RemoteAccrual)
+		if errors.Is(err, order.ErrOrderIsFinal) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return s.repo.ApplyCorrection(ctx, m)
+}
+
+// The client waits for the rate limit pause itself, so just try again.
+func (s *service) getOrderAccrual(ctx context.Context, orderNum string) (*accrual.OrderAccrual, error) {
+	for i := 0; ; i++ {
+		remote, err := s.accrualClient.GetOrderAccrual(ctx, orderNum)
+		if errors.Is(err, accrual.ErrRateLimited) && i < maxRateLimitRetries {
+			continue
+		}
+		return remote, err
+	}
+}
+
+// Returns `nil` if there is no drift. `remote` is `nil` for orders unknown upstream.
+func compare(local *Order, remote *accrual.OrderAccrual) *Mismatch {
+	m := &Mismatch{
+		Order:        local.Number,
+		UserID:       local.UserID,
+		LocalStatus:  local.Status,
+		LocalAccrual: local.Accrual,
+	}
+
+	if remote == nil {
+		m.Kind = MissingUpstream
+		return m
+	}
+	m.RemoteStatus = remote.Status
+	m.RemoteAccrual = remote.Accrual
+
+	switch {
+	case local.Status == order.PROCESSED && remote.Status == accrual.PROCESSED:
+		if local.Accrual == remote.Accrual {
+			return nil
+		}
+		m.Kind = AmountMismatch
+	case order.IsFinal(local.Status) && local.Status != remote.Status,
+		isFinal(remote.Status) && local.Status != remote.Status:
+		m.Kind = StatusMismatch
+	default:
+		return nil
+	}
+	return m
+}
+
+// STALLED orders are final for polling only, the accrual system can still finish them.
+func isPending(localStatus string) bool {
+	return localStatus != order.PROCESSED && localStatus != order.INVALID
+}
+
+func isFinal(accrualStatus string) bool {
+	return accrualStatus == accrual.PROCESSED || accrualStatus == accrual.INVALID
+}
+
+// Points credited to the user for an order in the given state.
+func credited(status string, amount money.Amount) money.Amount {
+	if status == order.PROCESSED {
+		return amount
+	}
+	return 0
+}
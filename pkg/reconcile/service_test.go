@@ -0,0 +1,92 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/accrual"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/order"
+)
+
+type stubReconcileRepo struct {
+	orders    []*Order
+	corrected []string
+}
+
+func (r *stubReconcileRepo) GetOrders(ctx context.Context, from, to time.Time) ([]*Order, error) {
+	return r.orders, nil
+}
+
+func (r *stubReconcileRepo) ApplyCorrection(ctx context.Context, m *Mismatch) (bool, error) {
+	r.corrected = append(r.corrected, m.Order)
+	return true, nil
+}
+
+type stubAccrualClient map[string]*accrual.OrderAccrual
+
+func (c stubAccrualClient) GetOrderAccrual(ctx context.Context, orderNum string) (*accrual.OrderAccrual, error) {
+	if oa, ok := c[orderNum]; ok {
+		return oa, nil
+	}
+	return nil, accrual.ErrOrderNotRegistered
+}
+
+type stubOrderUpdater struct {
+	final   map[string]bool // orders finished meanwhile
+	updated []string
+}
+
+func (u *stubOrderUpdater) UpdateOrderStatus(ctx context.Context, userID, orderID, newStatus string, accrual money.Amount) error {
+	if u.final[orderID] {
+		return order.ErrOrderIsFinal
+	}
+	u.updated = append(u.updated, orderID)
+	return nil
+}
+
+func TestReconcileCorrectsPendingOrdersLikePolling(t *testing.T) {
+	repo := &stubReconcileRepo{orders: []*Order{
+		{Number: "1", UserID: "u1", Status: order.NEW},
+		{Number: "2", UserID: "u1", Status: order.PROCESSING},
+		{Number: "3", UserID: "u1", Status: order.STALLED},
+		{Number: "4", UserID: "u1", Status: order.PROCESSED, Accrual: 500},
+		{Number: "5", UserID: "u1", Status: order.NEW},
+		{Number: "6", UserID: "u1", Status: order.PROCESSING},
+	}}
+	client := stubAccrualClient{
+		"1": {Order: "1", Status: accrual.PROCESSED, Accrual: 500},
+		"2": {Order: "2", Status: accrual.INVALID},
+		"3": {Order: "3", Status: accrual.PROCESSED, Accrual: 100},
+		"4": {Order: "4", Status: accrual.PROCESSED, Accrual: 700},
+		"5": {Order: "5", Status: accrual.PROCESSED, Accrual: 100},
+		"6": {Order: "6", Status: accrual.PROCESSING},
+	}
+	orders := &stubOrderUpdater{final: map[string]bool{"5": true}}
+
+	report, err := NewService(repo, client, orders).Reconcile(context.Background(), time.Time{}, time.Now(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := orders.updated; len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" {
+		t.Errorf("updated like polling: %v, want pending orders 1, 2 and 3", got)
+	}
+	if got := repo.corrected; len(got) != 1 || got[0] != "4" {
+		t.Errorf("adjusted: %v, want the processed order 4", got)
+	}
+	corrected := map[string]bool{}
+	for _, m := range report.Mismatches {
+		corrected[m.Order] = m.Corrected
+	}
+	want := map[string]bool{"1": true, "2": true, "3": true, "4": true, "5": false}
+	if len(corrected) != len(want) {
+		t.Errorf("mismatches: %v, want %v", corrected, want)
+	}
+	for o, c := range want {
+		if corrected[o] != c {
+			t.Errorf("order %s corrected = %v, want %v", o, corrected[o], c)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package referral
+
+import (
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Referral statuses.
+const (
+	PENDING  = "PENDING"  // waiting for the first PROCESSED order of the referee with accrual
+	REWARDED = "REWARDED" // both users got the reward
+	REJECTED = "REJECTED" // looks like abuse, never rewarded
+)
+
+// Why the referral is rejected.
+const (
+	// The referee registered from the same address as the referrer,
+	// checked only when the client address header is configured.
+	ReasonSelfReferral = "SELF_REFERRAL"
+	// The referrer was referred too and hasn't got a PROCESSED order with accrual yet,
+	// so accounts can't be chained to farm rewards.
+	ReasonChain = "CHAIN"
+)
+
+// A user invited by the referrer.
+type Referral struct {
+	Login      string       `json:"login"`
+	Status     string       `json:"status"`
+	Reason     string       `json:"reason,omitempty"`
+	Reward     money.Amount `json:"reward,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	RewardedAt *time.Time   `json:"rewarded_at,omitempty"`
+}
+
+type Stats struct {
+	Code      string       `json:"code"`
+	Invited   int          `json:"invited"`
+	Pending   int          `json:"pending"`
+	Rewarded  int          `json:"rewarded"`
+	Rejected  int          `json:"rejected"`
+	Earned    money.Amount `json:"earned"`
+	Referrals []*Referral  `json:"referrals"`
+}
+
+// The referrer as seen by abuse checks.
+type referrer struct {
+	ID           string
+	RegisteredIP string
+	// Was referred and hasn't got a PROCESSED order with accrual yet
+	UnprovenReferee bool
+}
+
+// A referral waiting for the reward.
+type pending struct {
+	ID         int64
+	ReferrerID string
+	RefereeID  string
+}
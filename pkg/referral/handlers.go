@@ -0,0 +1,33 @@
+package referral
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+)
+
+type iService interface {
+	Stats(ctx context.Context) (*Stats, error)
+}
+
+type handler struct {
+	service iService
+}
+
+func NewHandler(s iService) *handler {
+	return &handler{
+		service: s,
+	}
+}
+
+func (h *handler) GetUserReferrals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats, err := h.service.Stats(r.Context())
+	if err != nil {
+		common.WriteMsg(w, "can't get user referrals", http.StatusInternalServerError)
+		return
+	}
+	common.WriteRespJSON(w, stats)
+}
@@ -0,0 +1,130 @@
+package referral
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/user"
+)
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+func (r *repo) GetReferrer(ctx context.Context, tx *sql.Tx, code string) (*referrer, error) {
+	q := `SELECT u.id, u.registered_ip,
+	        EXISTS (SELECT 1 FROM referrals r WHERE r.referee_id = u.id)
+	        AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id AND o.status = 'PROCESSED' AND o.accrual > 0)
+	      FROM users u WHERE u.referral_code = $1`
+	ref := new(referrer)
+	err := tx.QueryRowContext(ctx, q, code).Scan(&ref.ID, &ref.RegisteredIP, &ref.UnprovenReferee)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("referral/repo: code `%s`, %w", code, user.ErrUnknownReferralCode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("referral/repo: failed getting referrer by code `%s`, %w", code, err)
+	}
+	return ref, nil
+}
+
+func (r *repo) Create(ctx context.Context, tx *sql.Tx, referrerID, refereeID, status, reason string) error {
+	q := `INSERT INTO referrals(referrer_id, referee_id, status, reason) VALUES($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, q, referrerID, refereeID, status, reason); err != nil {
+		return fmt.Errorf("referral/repo: failed creating referral of user `%s`, %w", refereeID, err)
+	}
+	return nil
+}
+
+// The pending referral of the user, locked until the transaction ends. `nil` if there is none.
+func (r *repo) GetPending(ctx context.Context, tx *sql.Tx, refereeID string) (*pending, error) {
+	q := `SELECT id, referrer_id, referee_id FROM referrals WHERE referee_id = $1 AND status = $2 FOR UPDATE`
+	p := new(pending)
+	err := tx.QueryRowContext(ctx, q, refereeID, PENDING).Scan(&p.ID, &p.ReferrerID, &p.RefereeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("referral/repo: failed getting pending referral of user `%s`, %w", refereeID, err)
+	}
+	return p, nil
+}
+
+// Credits the reward to both users. Both credits are linked to the order,
+// so they are taken back if the order gets cancelled.
+func (r *repo) Reward(ctx context.Context, tx *sql.Tx, p *pending, orderID string, reward money.Amount) error {
+	credits := []*ledger.Posting{
+		{UserID: p.RefereeID},
+		{UserID: p.ReferrerID},
+	}
+	for _, c := range credits {
+		c.OrderID = orderID
+		c.Kind = ledger.BONUS
+		c.Debit = ledger.AccountReferrals
+		c.Credit = ledger.UserAccount(c.UserID)
+		c.Amount = reward
+		c.ReferralID = p.ID
+		if err := ledger.Post(ctx, tx, c); err != nil {
+			return fmt.Errorf("referral/repo: failed crediting referral reward to user `%s`, %w", c.UserID, err)
+		}
+	}
+
+	q := `UPDATE referrals SET status = $2, order_id = $3, reward = $4, rewarded_at = NOW() WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, q, p.ID, REWARDED, orderID, reward); err != nil {
+		return fmt.Errorf("referral/repo: failed updating referral `%d`, %w", p.ID, err)
+	}
+	return nil
+}
+
+func (r *repo) GetStats(ctx context.Context, userID string) (*Stats, error) {
+	stats := &Stats{Referrals: []*Referral{}}
+	err := r.db.QueryRowContext(ctx, `SELECT referral_code FROM users WHERE id = $1`, userID).Scan(&stats.Code)
+	if err != nil {
+		return nil, fmt.Errorf("referral/repo: failed getting referral code of user `%s`, %w", userID, err)
+	}
+
+	q := `SELECT u.login, r.status, r.reason, r.reward, r.created_at, r.rewarded_at
+	      FROM referrals r JOIN users u ON u.id = r.referee_id
+	      WHERE r.referrer_id = $1 ORDER BY r.created_at DESC, r.id DESC`
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, fmt.Errorf("referral/repo: failed getting referrals of user `%s`, %w", userID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	for rows.Next() {
+		ref := new(Referral)
+		var rewardedAt sql.NullTime
+		if err := rows.Scan(&ref.Login, &ref.Status, &ref.Reason, &ref.Reward, &ref.CreatedAt, &rewardedAt); err != nil {
+			return nil, fmt.Errorf("scan referral row failed: %w", err)
+		}
+		if rewardedAt.Valid {
+			ref.RewardedAt = &rewardedAt.Time
+		}
+
+		stats.Invited++
+		switch ref.Status {
+		case PENDING:
+			stats.Pending++
+		case REWARDED:
+			stats.Rewarded++
+			stats.Earned += ref.Reward
+		case REJECTED:
+			stats.Rejected++
+		}
+		stats.Referrals = append(stats.Referrals, ref)
+	}
+	return stats, nil
+}
@@ -0,0 +1,98 @@
+package referral
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/order"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/user"
+)
+
+type iReferralRepo interface {
+	GetReferrer(ctx context.Context, tx *sql.Tx, code string) (*referrer, error)
+	Create(ctx context.Context, tx *sql.Tx, referrerID, refereeID, status, reason string) error
+	GetPending(ctx context.Context, tx *sql.Tx, refereeID string) (*pending, error)
+	Reward(ctx context.Context, tx *sql.Tx, p *pending, orderID string, reward money.Amount) error
+	GetStats(ctx context.Context, userID string) (*Stats, error)
+}
+
+type service struct {
+	repo   iReferralRepo
+	reward money.Amount
+}
+
+// `reward` is credited to each of the users, zero disables rewards.
+func NewService(r iReferralRepo, reward money.Amount) *service {
+	return &service{
+		repo:   r,
+		reward: reward,
+	}
+}
+
+// Registered user hook: links the new user to the referrer. Suspicious referrals
+// are kept as REJECTED, the registration itself goes on.
+func (s *service) Refer(ctx context.Context, tx *sql.Tx, u *user.User) error {
+	if u.ReferredBy == "" {
+		return nil
+	}
+
+	ref, err := s.repo.GetReferrer(ctx, tx, u.ReferredBy)
+	if err != nil {
+		return err
+	}
+
+	status, reason := PENDING, ""
+	switch {
+	// Addresses are recorded only when a trusted client address header is configured
+	case u.RegisteredIP != "" && ref.RegisteredIP == u.RegisteredIP:
+		status, reason = REJECTED, ReasonSelfReferral
+	case ref.UnprovenReferee:
+		status, reason = REJECTED, ReasonChain
+	}
+	if err := s.repo.Create(ctx, tx, ref.ID, u.ID, status, reason); err != nil {
+		return err
+	}
+
+	if status == REJECTED {
+		logger.Log(ctx).Warnf("referral: `%s` referred by user `%s` rejected, %s", u.Login, ref.ID, reason)
+	}
+	return nil
+}
+
+// Processed order hook: rewards both users when the referee's first order with
+// a positive accrual is PROCESSED. Orders accruing nothing don't prove a purchase.
+func (s *service) CreditRewards(ctx context.Context, tx *sql.Tx, o *order.Order) error {
+	if s.reward <= 0 || o.Accrual <= 0 {
+		return nil
+	}
+
+	p, err := s.repo.GetPending(ctx, tx, o.UserID)
+	if err != nil || p == nil {
+		return err
+	}
+	if err := s.repo.Reward(ctx, tx, p, o.Number, s.reward); err != nil {
+		return err
+	}
+	logger.Log(ctx).Infof("referral: rewarded users `%s` and `%s` with %s for `%s`",
+		p.ReferrerID, p.RefereeID, s.reward, o.Number)
+	return nil
+}
+
+// Referral code and invited users of the authorized user.
+func (s *service) Stats(ctx context.Context) (*Stats, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("referral: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	stats, err := s.repo.GetStats(ctx, userID)
+	if err != nil {
+		logger.Log(ctx).Errorf("referral: can't get referral stats, %v", err)
+		return nil, err
+	}
+	return stats, nil
+}
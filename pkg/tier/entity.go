@@ -0,0 +1,104 @@
+package tier
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Tiers are based on points accrued during this many last months.
+const WindowMonths = 12
+
+// Multipliers can't take points away.
+const minMultiplier money.Amount = 100 // 1.00
+
+var ErrBadTiers = errors.New("tier: bad tiers definition")
+
+type Tier struct {
+	Name       string       `json:"name"`
+	Threshold  money.Amount `json:"threshold"`  // points accrued during the window to get the tier
+	Multiplier money.Amount `json:"multiplier"` // order accruals are multiplied by it, e.g. `1.25`
+}
+
+// Tiers sorted by threshold, the first one is for everybody.
+type Tiers []*Tier
+
+// Parses a definition like `BRONZE:0:1,SILVER:1000:1.1,GOLD:5000:1.25`,
+// each tier is `name:threshold:multiplier`.
+func ParseTiers(s string) (Tiers, error) {
+	tiers := Tiers{}
+	seen := map[string]struct{}{}
+	for _, def := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(def), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%w: `%s` must be name:threshold:multiplier", ErrBadTiers, def)
+		}
+		t := &Tier{Name: strings.ToUpper(parts[0])}
+		if _, ok := seen[t.Name]; ok {
+			return nil, fmt.Errorf("%w: `%s` is defined twice", ErrBadTiers, t.Name)
+		}
+		seen[t.Name] = struct{}{}
+
+		var err error
+		if t.Threshold, err = money.Parse(parts[1]); err != nil || t.Threshold < 0 {
+			return nil, fmt.Errorf("%w: bad threshold of `%s`", ErrBadTiers, t.Name)
+		}
+		if t.Multiplier, err = money.Parse(parts[2]); err != nil || t.Multiplier < minMultiplier {
+			return nil, fmt.Errorf("%w: multiplier of `%s` must be at least 1", ErrBadTiers, t.Name)
+		}
+		tiers = append(tiers, t)
+	}
+
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Threshold < tiers[j].Threshold })
+	if tiers[0].Threshold != 0 {
+		return nil, fmt.Errorf("%w: the lowest tier must have zero threshold", ErrBadTiers)
+	}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Threshold == tiers[i-1].Threshold {
+			return nil, fmt.Errorf("%w: `%s` and `%s` have the same threshold", ErrBadTiers, tiers[i-1].Name, tiers[i].Name)
+		}
+	}
+	return tiers, nil
+}
+
+// The tier for `accrued` points and the next one, `nil` if it's the top tier.
+func (ts Tiers) For(accrued money.Amount) (current, next *Tier) {
+	current = ts[0]
+	for i, t := range ts {
+		if accrued < t.Threshold {
+			return current, ts[i]
+		}
+		current = t
+	}
+	return current, nil
+}
+
+// Reports whether there is a tier named `name`.
+func (ts Tiers) Has(name string) bool {
+	for _, t := range ts {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// A tier change of the user.
+type Change struct {
+	Tier      string       `json:"tier"`
+	Accrued   money.Amount `json:"accrued"`
+	ChangedAt time.Time    `json:"changed_at"`
+}
+
+type Status struct {
+	Tier       string       `json:"tier"`
+	Multiplier money.Amount `json:"multiplier"`
+	Accrued    money.Amount `json:"accrued"` // during the rolling window
+	NextTier   string       `json:"next_tier,omitempty"`
+	ToNextTier money.Amount `json:"to_next_tier,omitempty"` // points to accrue to get the next tier
+	History    []*Change    `json:"history"`
+}
@@ -0,0 +1,101 @@
+package tier
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Tier
+		wantErr bool
+	}{
+		{
+			in:   "BRONZE:0:1",
+			want: []Tier{{Name: "BRONZE", Threshold: 0, Multiplier: 100}},
+		},
+		{
+			in: "BRONZE:0:1,SILVER:1000:1.1,GOLD:5000:1.25",
+			want: []Tier{
+				{Name: "BRONZE", Threshold: 0, Multiplier: 100},
+				{Name: "SILVER", Threshold: 100000, Multiplier: 110},
+				{Name: "GOLD", Threshold: 500000, Multiplier: 125},
+			},
+		},
+		{
+			in: " gold:5000:1.25, bronze:0:1 ,silver:999.99:1.1",
+			want: []Tier{
+				{Name: "BRONZE", Threshold: 0, Multiplier: 100},
+				{Name: "SILVER", Threshold: 99999, Multiplier: 110},
+				{Name: "GOLD", Threshold: 500000, Multiplier: 125},
+			},
+		},
+		{in: "", wantErr: true},
+		{in: "BRONZE:0", wantErr: true},
+		{in: "BRONZE:0:1:2", wantErr: true},
+		{in: ":0:1", wantErr: true},
+		{in: "BRONZE:0:1,bronze:100:1.1", wantErr: true},
+		{in: "BRONZE:zero:1", wantErr: true},
+		{in: "BRONZE:-1:1", wantErr: true},
+		{in: "BRONZE:0:0.99", wantErr: true},
+		{in: "BRONZE:0:x", wantErr: true},
+		{in: "SILVER:1000:1.1", wantErr: true},
+		{in: "BRONZE:0:1,SILVER:1000:1.1,GOLD:1000:1.25", wantErr: true},
+		{in: "BRONZE:0:1,", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTiers(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrBadTiers) {
+				t.Errorf("ParseTiers(%q) error = %v, want ErrBadTiers", tt.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTiers(%q) error = %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseTiers(%q) returned %d tiers, want %d", tt.in, len(got), len(tt.want))
+			continue
+		}
+		for i, tier := range got {
+			if *tier != tt.want[i] {
+				t.Errorf("ParseTiers(%q)[%d] = %+v, want %+v", tt.in, i, *tier, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestTiersFor(t *testing.T) {
+	tiers, err := ParseTiers("BRONZE:0:1,SILVER:1000:1.1,GOLD:5000:1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		accrued     money.Amount
+		wantCurrent string
+		wantNext    string // empty for the top tier
+	}{
+		{accrued: 0, wantCurrent: "BRONZE", wantNext: "SILVER"},
+		{accrued: 99999, wantCurrent: "BRONZE", wantNext: "SILVER"},
+		{accrued: 100000, wantCurrent: "SILVER", wantNext: "GOLD"},
+		{accrued: 499999, wantCurrent: "SILVER", wantNext: "GOLD"},
+		{accrued: 500000, wantCurrent: "GOLD"},
+		{accrued: 10000000, wantCurrent: "GOLD"},
+	}
+	for _, tt := range tests {
+		current, next := tiers.For(tt.accrued)
+		nextName := ""
+		if next != nil {
+			nextName = next.Name
+		}
+		if current.Name != tt.wantCurrent || nextName != tt.wantNext {
+			t.Errorf("For(%s) = %s, %q, want %s, %q", tt.accrued, current.Name, nextName, tt.wantCurrent, tt.wantNext)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package tier
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+)
+
+type iService interface {
+	GetStatus(ctx context.Context) (*Status, error)
+}
+
+type handler struct {
+	service iService
+}
+
+func NewHandler(s iService) *handler {
+	return &handler{
+		service: s,
+	}
+}
+
+func (h *handler) GetUserTier(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status, err := h.service.GetStatus(r.Context())
+	if err != nil {
+		common.WriteMsg(w, "can't get user tier", http.StatusInternalServerError)
+		return
+	}
+	common.WriteRespJSON(w, status)
+}
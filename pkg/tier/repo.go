@@ -0,0 +1,113 @@
+package tier
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+// Points accrued by the user during the rolling window for orders which aren't
+// cancelled. Clawbacks aren't subtracted, they take bonuses back too. Bonuses
+// don't count, so tiers don't feed themselves.
+func (r *repo) Accrued(ctx context.Context, tx *sql.Tx, userID string) (money.Amount, error) {
+	q := `SELECT COALESCE(SUM(l.amount), 0) FROM ledger l JOIN orders o ON o.id = l.order_id
+	      WHERE l.user_id = $1 AND l.kind = $2 AND l.credit_account = $3 AND o.status <> 'CANCELLED'
+	        AND l.created_at > NOW() - make_interval(months => $4)`
+	var accrued money.Amount
+	err := tx.QueryRowContext(ctx, q, userID, ledger.ACCRUAL, ledger.UserAccount(userID), WindowMonths).Scan(&accrued)
+	if err != nil {
+		return 0, fmt.Errorf("tier/repo: failed getting points accrued by user `%s`, %w", userID, err)
+	}
+	return accrued, nil
+}
+
+func (r *repo) CreditBonus(ctx context.Context, tx *sql.Tx, userID, orderID string, bonus money.Amount) error {
+	err := ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:  userID,
+		Kind:    ledger.BONUS,
+		Debit:   ledger.AccountBonus,
+		Credit:  ledger.UserAccount(userID),
+		Amount:  bonus,
+		OrderID: orderID,
+	})
+	if err != nil {
+		return fmt.Errorf("tier/repo: failed crediting bonus for `%s`, %w", orderID, err)
+	}
+	return nil
+}
+
+// Adds the tier to the user history unless it's the last recorded one.
+func (r *repo) RecordTier(ctx context.Context, tx *sql.Tx, userID, tier string, accrued money.Amount) error {
+	q := `INSERT INTO user_tiers(user_id, tier, accrued)
+	      SELECT $1::integer, $2::varchar, $3::numeric
+	      WHERE $2::varchar IS DISTINCT FROM (
+	        SELECT tier FROM user_tiers WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1
+	      )`
+	if _, err := tx.ExecContext(ctx, q, userID, tier, accrued); err != nil {
+		return fmt.Errorf("tier/repo: failed recording tier of user `%s`, %w", userID, err)
+	}
+	return nil
+}
+
+// Records the tier the user has now. Tiers go down as old accruals leave the
+// window, so the history is brought up to date when the user asks for it.
+func (r *repo) SyncTier(ctx context.Context, userID string, tierFor func(money.Amount) string) (money.Amount, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("tier/repo: failed init sync tier transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serializes with accrual credits of the user which lock the row too
+	if _, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return 0, fmt.Errorf("tier/repo: failed locking user `%s`, %w", userID, err)
+	}
+
+	accrued, err := r.Accrued(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err = r.RecordTier(ctx, tx, userID, tierFor(accrued), accrued); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("tier/repo: failed committing sync tier transaction, %w", err)
+	}
+	return accrued, nil
+}
+
+func (r *repo) GetHistory(ctx context.Context, userID string) ([]*Change, error) {
+	q := `SELECT tier, accrued, created_at FROM user_tiers WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, fmt.Errorf("tier/repo: failed getting tier history of user `%s`, %w", userID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	history := []*Change{}
+	for rows.Next() {
+		c := new(Change)
+		if err := rows.Scan(&c.Tier, &c.Accrued, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("scan tier change row failed: %w", err)
+		}
+		history = append(history, c)
+	}
+	return history, nil
+}
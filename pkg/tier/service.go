@@ -0,0 +1,89 @@
+package tier
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/order"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
+)
+
+type iTierRepo interface {
+	Accrued(ctx context.Context, tx *sql.Tx, userID string) (money.Amount, error)
+	CreditBonus(ctx context.Context, tx *sql.Tx, userID, orderID string, bonus money.Amount) error
+	RecordTier(ctx context.Context, tx *sql.Tx, userID, tier string, accrued money.Amount) error
+	SyncTier(ctx context.Context, userID string, tierFor func(money.Amount) string) (money.Amount, error)
+	GetHistory(ctx context.Context, userID string) ([]*Change, error)
+}
+
+type service struct {
+	repo  iTierRepo
+	tiers Tiers
+}
+
+func NewService(r iTierRepo, tiers Tiers) *service {
+	return &service{
+		repo:  r,
+		tiers: tiers,
+	}
+}
+
+// Processed order hook: credits the bonus of the tier the user had before
+// the order and records the tier change the order brings.
+func (s *service) CreditBonus(ctx context.Context, tx *sql.Tx, o *order.Order) error {
+	// Includes the order, its accrual is already credited
+	accrued, err := s.repo.Accrued(ctx, tx, o.UserID)
+	if err != nil {
+		return err
+	}
+
+	current, _ := s.tiers.For(accrued - o.Accrual)
+	if bonus := o.Accrual.Mul(current.Multiplier) - o.Accrual; bonus > 0 {
+		if err := s.repo.CreditBonus(ctx, tx, o.UserID, o.Number, bonus); err != nil {
+			return err
+		}
+		logger.Log(ctx).Infof("tier: %s bonus %s for `%s`", current.Name, bonus, o.Number)
+	}
+
+	return s.repo.RecordTier(ctx, tx, o.UserID, s.tierName(accrued), accrued)
+}
+
+// The tier of the authorized user, the progress to the next one and the tier history.
+func (s *service) GetStatus(ctx context.Context) (*Status, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("tier: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	accrued, err := s.repo.SyncTier(ctx, userID, s.tierName)
+	if err != nil {
+		logger.Log(ctx).Errorf("tier: can't sync user tier, %v", err)
+		return nil, err
+	}
+
+	current, next := s.tiers.For(accrued)
+	status := &Status{
+		Tier:       current.Name,
+		Multiplier: current.Multiplier,
+		Accrued:    accrued,
+	}
+	if next != nil {
+		status.NextTier = next.Name
+		status.ToNextTier = next.Threshold - accrued
+	}
+
+	status.History, err = s.repo.GetHistory(ctx, userID)
+	if err != nil {
+		logger.Log(ctx).Errorf("tier: can't get tier history, %v", err)
+		return nil, err
+	}
+	return status, nil
+}
+
+func (s *service) tierName(accrued money.Amount) string {
+	t, _ := s.tiers.For(accrued)
+	return t.Name
+}
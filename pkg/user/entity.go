@@ -1,7 +1,23 @@
 package user
 
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
 type User struct {
-	ID       string `json:"id"`
-	Login    string `json:"login"`
-	Password []byte `json:"-"`
+	ID           string `json:"id"`
+	Login        string `json:"login"`
+	Password     []byte `json:"-"`
+	ReferralCode string `json:"referral_code"` // the code the user invites others with
+	ReferredBy   string `json:"-"`             // the referral code used at registration, if any
+	RegisteredIP string `json:"-"`
 }
+
+// Called inside the transaction which adds a new user, so the registration
+// fails if a hook fails. The user ID is already set.
+type RegisteredHook func(ctx context.Context, tx *sql.Tx, u *User) error
+
+// Returned by registered hooks when the user was referred with a code nobody has.
+var ErrUnknownReferralCode = errors.New("unknown referral code")
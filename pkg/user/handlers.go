@@ -6,44 +6,59 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
 )
 
 type iService interface {
-	RegUser(ctx context.Context, login, pass string) (token string, err error)
+	RegUser(ctx context.Context, login, pass, referralCode, ip string) (token string, err error)
 	LoginUser(ctx context.Context, login, password string) (token string, err error)
 	LogOutUser(ctx context.Context) error
 }
 
 type handler struct {
-	service iService
+	service  iService
+	ipHeader string
 }
 
-func NewHandler(s iService) *handler {
+// `ipHeader` is the trusted header the proxy puts the client address into,
+// e.g. `X-Real-IP`. Without it client addresses aren't recorded.
+func NewHandler(s iService, ipHeader string) *handler {
 	return &handler{
-		service: s,
+		service:  s,
+		ipHeader: ipHeader,
 	}
 }
 
 func (h *handler) Register(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	login, pass, err := userFromRequest(r.Body)
-	if err != nil {
+	httpUser := &struct {
+		Login        string `json:"login"`
+		Password     string `json:"password"`
+		ReferralCode string `json:"referral_code"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(httpUser); err != nil {
 		logger.Log(r.Context()).Errorf("can't parse request body as user: %v", err)
 		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
 		return
 	}
+	login := httpUser.Login
 
-	token, err := h.service.RegUser(r.Context(), login, pass)
+	token, err := h.service.RegUser(r.Context(), login, httpUser.Password, httpUser.ReferralCode, h.clientIP(r))
 	if errors.Is(err, errUserAlreadyExists) {
 		msg := fmt.Sprintf(`user "%s" already exists`, login)
 		common.WriteMsg(w, msg, http.StatusConflict)
 		return
 	}
+	if errors.Is(err, ErrUnknownReferralCode) {
+		common.WriteValidationMsg(w, "referral code is not valid", "referral_code", "unknown")
+		return
+	}
 	if err != nil {
 		common.WriteMsg(w, "can't add user", http.StatusInternalServerError)
 		return
@@ -87,6 +102,22 @@ func (h *handler) LogOut(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// The client address from the trusted header, empty if it isn't configured or set.
+// `RemoteAddr` is never used, behind a load balancer all users share it.
+// For lists like `X-Forwarded-For` the last address is taken, it's the one
+// added by the proxy in front of the app.
+func (h *handler) clientIP(r *http.Request) string {
+	if h.ipHeader == "" {
+		return ""
+	}
+	addrs := strings.Split(r.Header.Get(h.ipHeader), ",")
+	ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-1]))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
 func userFromRequest(reqBody io.ReadCloser) (login, password string, err error) {
 	httpUser := &struct {
 		Login    string `json:"login"`
@@ -12,7 +12,8 @@ import (
 )
 
 type repo struct {
-	db *sql.DB
+	db              *sql.DB
+	registeredHooks []RegisteredHook
 }
 
 func NewRepo(db *sql.DB) *repo {
@@ -21,14 +22,42 @@ func NewRepo(db *sql.DB) *repo {
 	}
 }
 
+// Registers the hook called for every new user. Hooks are called in the order
+// they were added. Not safe for concurrent use, all hooks must be added on startup.
+func (r *repo) AddRegisteredHook(h RegisteredHook) {
+	r.registeredHooks = append(r.registeredHooks, h)
+}
+
 func (r *repo) Add(ctx context.Context, u *User) (string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ``, fmt.Errorf("user/repo: failed init add user transaction, %w", err)
+	}
+	defer tx.Rollback()
+
 	userID := 0
-	err := r.db.QueryRowContext(ctx, "INSERT INTO users(login, password) VALUES($1, $2) RETURNING id",
-		u.Login, u.Password).Scan(&userID)
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO users(login, password, referral_code, registered_ip) VALUES($1, $2, $3, $4) RETURNING id",
+		u.Login, u.Password, u.ReferralCode, u.RegisteredIP).Scan(&userID)
+	if common.IsUniqueViolationOf(err, referralCodeIndex) {
+		return ``, fmt.Errorf("user/repo: referral code `%s`, %w", u.ReferralCode, errReferralCodeTaken)
+	}
 	if err != nil {
 		return ``, fmt.Errorf("user/repo: failed insert user, %w", err)
 	}
-	return strconv.Itoa(userID), nil
+
+	added := *u
+	added.ID = strconv.Itoa(userID)
+	for _, hook := range r.registeredHooks {
+		if err = hook(ctx, tx, &added); err != nil {
+			return ``, fmt.Errorf("user/repo: registered hook failed for `%s`, %w", u.Login, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return ``, fmt.Errorf("user/repo: failed committing add user transaction, %w", err)
+	}
+	return added.ID, nil
 }
 
 func (r *repo) GetByLoginAndPass(ctx context.Context, uname string, pass string) (*User, error) {
@@ -2,8 +2,11 @@ package user
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 
 	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
 	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
@@ -28,6 +31,7 @@ type service struct {
 var (
 	errUserAlreadyExists = errors.New("user already exists")
 	errUserNotFound      = errors.New("user not found")
+	errReferralCodeTaken = errors.New("referral code is taken")
 )
 
 func NewService(r iUserRepo, sess iSessionService) *service {
@@ -57,7 +61,31 @@ func (s *service) LoginUser(ctx context.Context, login, password string) (token
 	return
 }
 
-func (s *service) RegUser(ctx context.Context, login, password string) (token string, err error) {
+// Referral codes are random, with this many characters collisions are rare,
+// the unique index catches them and the user is added with another code.
+const (
+	referralCodeLength   = 10
+	referralCodeAttempts = 3
+	referralCodeIndex    = "users_referral_code_idx"
+)
+
+const referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func newReferralCode() (string, error) {
+	b := make([]byte, referralCodeLength)
+	max := big.NewInt(int64(len(referralCodeAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = referralCodeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// Registers the user, `referralCode` is the code of the user who invited them, if any.
+func (s *service) RegUser(ctx context.Context, login, password, referralCode, ip string) (token string, err error) {
 	userExists, _ := s.repo.UserExists(ctx, login)
 	if userExists {
 		logger.Log(ctx).Error(`user "%s" already exists`, login)
@@ -67,11 +95,28 @@ func (s *service) RegUser(ctx context.Context, login, password string) (token st
 	salt := common.RandStringRunes(8)
 	pass := common.HashPass(password, salt)
 	user := &User{
-		Login:    login,
-		Password: pass,
+		Login:        login,
+		Password:     pass,
+		ReferredBy:   strings.ToUpper(strings.TrimSpace(referralCode)),
+		RegisteredIP: ip,
 		// Id is handled below
 	}
-	id, err := s.repo.Add(ctx, user)
+	var id string
+	for i := 0; i < referralCodeAttempts; i++ {
+		if user.ReferralCode, err = newReferralCode(); err != nil {
+			logger.Log(ctx).Errorf("user: can't generate referral code, %v", err)
+			return ``, err
+		}
+		id, err = s.repo.Add(ctx, user)
+		if !errors.Is(err, errReferralCodeTaken) {
+			break
+		}
+		logger.Log(ctx).Infof("user: %v, trying another one", err)
+	}
+	if errors.Is(err, ErrUnknownReferralCode) {
+		logger.Log(ctx).Infof("user: can't add `%s`, %v", login, err)
+		return ``, err
+	}
 	if err != nil {
 		logger.Log(ctx).Errorf("user: can't add user to DB: %v", err)
 		return
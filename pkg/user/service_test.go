@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Run("fatal")
+	os.Exit(m.Run())
+}
+
+// Fails `taken` first attempts with a referral code collision.
+type stubUserRepo struct {
+	taken int
+	codes []string
+}
+
+func (r *stubUserRepo) UserExists(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (r *stubUserRepo) GetByLoginAndPass(context.Context, string, string) (*User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *stubUserRepo) Add(ctx context.Context, u *User) (string, error) {
+	r.codes = append(r.codes, u.ReferralCode)
+	if len(r.codes) <= r.taken {
+		return ``, fmt.Errorf("user/repo: referral code `%s`, %w", u.ReferralCode, errReferralCodeTaken)
+	}
+	return "1", nil
+}
+
+type stubSessionService struct{}
+
+func (stubSessionService) CreateToken(u *User) (string, error) {
+	return "token-" + u.ID, nil
+}
+
+func (stubSessionService) DestroySession(context.Context) error {
+	return nil
+}
+
+func TestRegUserRetriesTakenReferralCode(t *testing.T) {
+	repo := &stubUserRepo{taken: referralCodeAttempts - 1}
+	token, err := NewService(repo, stubSessionService{}).RegUser(context.Background(), "alice", "secret", "", "")
+	if err != nil || token != "token-1" {
+		t.Fatalf("RegUser() = %q, %v, want the user added with a fresh code", token, err)
+	}
+	if len(repo.codes) != referralCodeAttempts || repo.codes[0] == repo.codes[1] {
+		t.Errorf("tried codes %v, want %d different ones", repo.codes, referralCodeAttempts)
+	}
+}
+
+func TestRegUserGivesUpOnTakenReferralCodes(t *testing.T) {
+	repo := &stubUserRepo{taken: referralCodeAttempts}
+	_, err := NewService(repo, stubSessionService{}).RegUser(context.Background(), "alice", "secret", "", "")
+	if !errors.Is(err, errReferralCodeTaken) {
+		t.Errorf("RegUser() error = %v, want %v", err, errReferralCodeTaken)
+	}
+	if len(repo.codes) != referralCodeAttempts {
+		t.Errorf("Add was called %d times, want %d", len(repo.codes), referralCodeAttempts)
+	}
+}
+
+func TestNewReferralCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := newReferralCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != referralCodeLength || seen[code] {
+			t.Fatalf("newReferralCode() = %q, want a new %d character code", code, referralCodeLength)
+		}
+		seen[code] = true
+	}
+}
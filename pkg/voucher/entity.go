@@ -0,0 +1,118 @@
+package voucher
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/money"
+)
+
+// Codes are printed in groups like `ABCD-EFGH-JKLM-NPQ7`, the last character is
+// the checksum. Letters and digits which look alike are left out.
+const (
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	codeLength   = 16 // including the checksum
+	codeGroup    = 4
+)
+
+// Codes are handed out by partners, so an admin can't generate more at once.
+const MaxBatchSize = 10000
+
+// Codes generated at once, all of them are worth `Value` and expire together.
+type Batch struct {
+	ID         int64        `json:"id"`
+	Name       string       `json:"name"`
+	Count      int          `json:"count"`
+	Value      money.Amount `json:"value"`
+	UsageLimit int          `json:"usage_limit"` // redemptions per code, each user can redeem a code once
+	ExpiresAt  time.Time    `json:"expires_at"`
+	Redeemed   int          `json:"redeemed"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Codes      []string     `json:"codes,omitempty"` // only right after generation
+}
+
+type Redemption struct {
+	ID         int64        `json:"-"`
+	Code       string       `json:"code"`
+	Sum        money.Amount `json:"sum"`
+	RedeemedAt time.Time    `json:"redeemed_at"`
+}
+
+// A random code with the checksum, in the form it's stored.
+func newCode() (string, error) {
+	b := make([]byte, codeLength-1)
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = codeAlphabet[n.Int64()]
+	}
+	payload := string(b)
+	return payload + string(checkChar(payload)), nil
+}
+
+// Luhn mod N over the code alphabet, catches every single typo and most swapped neighbours.
+func checkChar(payload string) byte {
+	n := len(codeAlphabet)
+	factor := 2
+	sum := 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(codeAlphabet, payload[i])
+		sum += addend/n + addend%n
+		factor = 3 - factor
+	}
+	return codeAlphabet[(n-sum%n)%n]
+}
+
+// Brings a code typed by the user to the stored form.
+func normalizeCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Reports whether the normalized code is well-formed and its checksum matches.
+func validCode(code string) bool {
+	if len(code) != codeLength {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if strings.IndexByte(codeAlphabet, code[i]) < 0 {
+			return false
+		}
+	}
+	payload := code[:codeLength-1]
+	return checkChar(payload) == code[codeLength-1]
+}
+
+// Splits the code into groups for printing.
+func formatCode(code string) string {
+	groups := make([]string, 0, len(code)/codeGroup+1)
+	for len(code) > codeGroup {
+		groups = append(groups, code[:codeGroup])
+		code = code[codeGroup:]
+	}
+	groups = append(groups, code)
+	return strings.Join(groups, "-")
+}
+
+var ErrInvalid = errors.New("invalid voucher batch")
+
+// Describes which field of the batch is invalid, matches `ErrInvalid` with `errors.Is`.
+type ValidationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s %s", ErrInvalid, e.Field, e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
@@ -0,0 +1,102 @@
+package voucher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := newCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !validCode(code) {
+			t.Fatalf("newCode() = %s, which isn't valid", code)
+		}
+	}
+}
+
+func TestValidCode(t *testing.T) {
+	payload := "ABCDEFGHJKLMNPQ"
+	valid := payload + string(checkChar(payload))
+	wrongCheck := payload + string(codeAlphabet[(strings.IndexByte(codeAlphabet, checkChar(payload))+1)%len(codeAlphabet)])
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{name: "valid", code: valid, want: true},
+		{name: "wrong checksum", code: wrongCheck},
+		{name: "too short", code: valid[:codeLength-1]},
+		{name: "too long", code: valid + "A"},
+		{name: "empty", code: ""},
+		{name: "lowercase", code: "abcdefghjklmnpq" + valid[codeLength-1:]},
+		{name: "look-alike letter", code: "IBCDEFGHJKLMNPQ" + valid[codeLength-1:]},
+		{name: "look-alike digit", code: "0BCDEFGHJKLMNPQ" + valid[codeLength-1:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validCode(tt.code); got != tt.want {
+				t.Errorf("validCode(%q) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidCodeCatchesTypos(t *testing.T) {
+	payloads := []string{"ABCDEFGHJKLMNPQ", "ZZZZZZZZZZZZZZZ", "234567892345678", "AAAAAAAAAAAAAAA"}
+	for _, payload := range payloads {
+		code := payload + string(checkChar(payload))
+		for i := 0; i < len(code); i++ {
+			for j := 0; j < len(codeAlphabet); j++ {
+				if codeAlphabet[j] == code[i] {
+					continue
+				}
+				typo := code[:i] + string(codeAlphabet[j]) + code[i+1:]
+				if validCode(typo) {
+					t.Errorf("validCode(%s) = true, it's %s with a typo at %d", typo, code, i)
+				}
+			}
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "ABCD-EFGH-JKLM-NPQR", want: "ABCDEFGHJKLMNPQR"},
+		{in: "abcd efgh jklm npqr", want: "ABCDEFGHJKLMNPQR"},
+		{in: " Abcd-Efgh jklm-NPQR ", want: "ABCDEFGHJKLMNPQR"},
+		{in: "ABCDEFGHJKLMNPQR", want: "ABCDEFGHJKLMNPQR"},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeCode(tt.in); got != tt.want {
+			t.Errorf("normalizeCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "ABCDEFGHJKLMNPQR", want: "ABCD-EFGH-JKLM-NPQR"},
+		{in: "ABCDEF", want: "ABCD-EF"},
+		{in: "ABCD", want: "ABCD"},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := formatCode(tt.in); got != tt.want {
+			t.Errorf("formatCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := normalizeCode(formatCode(tt.in)); got != tt.in {
+			t.Errorf("normalizeCode(formatCode(%q)) = %q", tt.in, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package voucher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+)
+
+type iService interface {
+	CreateBatch(ctx context.Context, b *Batch) (*Batch, error)
+	Batches(ctx context.Context) ([]*Batch, error)
+	Redeem(ctx context.Context, code string) (*Redemption, error)
+}
+
+type handler struct {
+	service iService
+}
+
+func NewHandler(s iService) *handler {
+	return &handler{
+		service: s,
+	}
+}
+
+func (h *handler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	b := new(Batch)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		logger.Log(r.Context()).Errorf("can't parse request body as voucher batch: %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.service.CreateBatch(r.Context(), b)
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		common.WriteValidationMsg(w, "voucher batch is not valid", validationErr.Field, validationErr.Reason)
+	case err != nil:
+		common.WriteMsg(w, "failed to generate vouchers", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		common.WriteRespJSON(w, created)
+	}
+}
+
+func (h *handler) Batches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	batches, err := h.service.Batches(r.Context())
+	if err != nil {
+		common.WriteMsg(w, "can't get voucher batches", http.StatusInternalServerError)
+		return
+	}
+	common.WriteRespJSON(w, batches)
+}
+
+func (h *handler) Redeem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req := &struct {
+		Code string `json:"code"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		logger.Log(r.Context()).Errorf("can't parse request body as voucher code: %v", err)
+		common.WriteMsg(w, "bad request format", http.StatusBadRequest)
+		return
+	}
+
+	red, err := h.service.Redeem(r.Context(), req.Code)
+	switch {
+	case errors.Is(err, errBadCode):
+		common.WriteValidationMsg(w, "voucher code is not valid", "code", "checksum")
+	case errors.Is(err, errVoucherNotFound):
+		common.WriteMsg(w, "voucher not found", http.StatusNotFound)
+	case errors.Is(err, errVoucherExpired):
+		common.WriteMsg(w, "voucher expired", http.StatusGone)
+	case errors.Is(err, errAlreadyRedeemed):
+		common.WriteMsg(w, "voucher is already redeemed", http.StatusConflict)
+	case errors.Is(err, errVoucherUsedUp):
+		common.WriteMsg(w, "voucher usage limit reached", http.StatusConflict)
+	case err != nil:
+		common.WriteMsg(w, "failed to redeem voucher", http.StatusInternalServerError)
+	default:
+		common.WriteRespJSON(w, red)
+	}
+}
@@ -0,0 +1,164 @@
+package voucher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/common"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/ledger"
+)
+
+type repo struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *repo {
+	return &repo{
+		db: db,
+	}
+}
+
+// Stores the batch with `b.Count` new codes, the codes are returned formatted for printing.
+func (r *repo) CreateBatch(ctx context.Context, b *Batch) (*Batch, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed init create batch transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	created := *b
+	q := `INSERT INTO voucher_batches(name, value, usage_limit, expires_at) VALUES($1, $2, $3, $4)
+	      RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, q, b.Name, b.Value, b.UsageLimit, b.ExpiresAt).Scan(&created.ID, &created.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed creating batch, %w", err)
+	}
+
+	created.Codes = make([]string, 0, b.Count)
+	for len(created.Codes) < b.Count {
+		code, err := newCode()
+		if err != nil {
+			return nil, fmt.Errorf("voucher/repo: failed generating code, %w", err)
+		}
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO vouchers(code, batch_id) VALUES($1, $2) ON CONFLICT (code) DO NOTHING`, code, created.ID)
+		if err != nil {
+			return nil, fmt.Errorf("voucher/repo: failed inserting code, %w", err)
+		}
+		// Taken by another batch, try another one
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		created.Codes = append(created.Codes, formatCode(code))
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed committing create batch transaction, %w", err)
+	}
+	return &created, nil
+}
+
+func (r *repo) GetBatches(ctx context.Context) ([]*Batch, error) {
+	q := `SELECT b.id, b.name, b.value, b.usage_limit, b.expires_at, b.created_at,
+	        (SELECT COUNT(*) FROM vouchers v WHERE v.batch_id = b.id),
+	        COALESCE((SELECT SUM(v.used) FROM vouchers v WHERE v.batch_id = b.id), 0)
+	      FROM voucher_batches b ORDER BY b.created_at DESC, b.id DESC`
+	rows, err := r.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed getting batches, %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err()
+	}()
+
+	batches := []*Batch{}
+	for rows.Next() {
+		b := new(Batch)
+		err := rows.Scan(&b.ID, &b.Name, &b.Value, &b.UsageLimit, &b.ExpiresAt, &b.CreatedAt, &b.Count, &b.Redeemed)
+		if err != nil {
+			return nil, fmt.Errorf("scan voucher batch row failed: %w", err)
+		}
+		batches = append(batches, b)
+	}
+	return batches, nil
+}
+
+// Credits the voucher value to the user. The conditional update of the usage
+// counter serializes concurrent redemptions of the code and the unique
+// redemption per user makes it credited once for everybody.
+func (r *repo) Redeem(ctx context.Context, userID, code string) (*Redemption, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed init redeem transaction, %w", err)
+	}
+	defer tx.Rollback()
+
+	red := &Redemption{Code: code}
+	q := `UPDATE vouchers v SET used = v.used + 1
+	      FROM voucher_batches b
+	      WHERE v.code = $1 AND b.id = v.batch_id AND v.used < b.usage_limit AND b.expires_at > NOW()
+	        AND NOT EXISTS (SELECT 1 FROM voucher_redemptions r WHERE r.code = v.code AND r.user_id = $2)
+	      RETURNING b.value`
+	err = tx.QueryRowContext(ctx, q, code, userID).Scan(&red.Sum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, whyNotRedeemable(ctx, tx, userID, code)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed using code `%s`, %w", code, err)
+	}
+
+	q = `INSERT INTO voucher_redemptions(code, user_id, sum) VALUES($1, $2, $3) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, q, code, userID, red.Sum).Scan(&red.ID, &red.RedeemedAt)
+	if common.IsUniqueViolation(err) {
+		// A concurrent redemption by the same user got there first
+		return nil, fmt.Errorf("voucher/repo: code `%s` by user `%s`, %w", code, userID, errAlreadyRedeemed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed inserting redemption, %w", err)
+	}
+
+	err = ledger.Post(ctx, tx, &ledger.Posting{
+		UserID:       userID,
+		Kind:         ledger.VOUCHER,
+		Debit:        ledger.AccountVouchers,
+		Credit:       ledger.UserAccount(userID),
+		Amount:       red.Sum,
+		RedemptionID: red.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed crediting code `%s`, %w", code, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("voucher/repo: failed committing redeem transaction, %w", err)
+	}
+	red.Code = formatCode(code)
+	return red, nil
+}
+
+func whyNotRedeemable(ctx context.Context, tx *sql.Tx, userID, code string) error {
+	var redeemed, expired, usedUp bool
+	q := `SELECT EXISTS (SELECT 1 FROM voucher_redemptions r WHERE r.code = v.code AND r.user_id = $2),
+	        b.expires_at <= NOW(), v.used >= b.usage_limit
+	      FROM vouchers v JOIN voucher_batches b ON b.id = v.batch_id
+	      WHERE v.code = $1`
+	err := tx.QueryRowContext(ctx, q, code, userID).Scan(&redeemed, &expired, &usedUp)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = errVoucherNotFound
+	case err != nil:
+		return fmt.Errorf("voucher/repo: failed checking code `%s`, %w", code, err)
+	case redeemed:
+		err = errAlreadyRedeemed
+	case expired:
+		err = errVoucherExpired
+	case usedUp:
+		err = errVoucherUsedUp
+	default:
+		return fmt.Errorf("voucher/repo: code `%s` changed while redeeming", code)
+	}
+	return fmt.Errorf("voucher/repo: code `%s` by user `%s`, %w", code, userID, err)
+}
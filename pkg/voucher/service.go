@@ -0,0 +1,102 @@
+package voucher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amiskov/cumulative-loyalty-system/pkg/logger"
+	"github.com/amiskov/cumulative-loyalty-system/pkg/session"
+)
+
+type iVoucherRepo interface {
+	CreateBatch(ctx context.Context, b *Batch) (*Batch, error)
+	GetBatches(ctx context.Context) ([]*Batch, error)
+	Redeem(ctx context.Context, userID, code string) (*Redemption, error)
+}
+
+var (
+	errBadCode         = errors.New("bad voucher code")
+	errVoucherNotFound = errors.New("voucher not found")
+	errVoucherExpired  = errors.New("voucher expired")
+	errVoucherUsedUp   = errors.New("voucher usage limit reached")
+	errAlreadyRedeemed = errors.New("voucher already redeemed")
+)
+
+// Batch names are stored in VARCHAR(128) columns.
+const maxNameLength = 128
+
+type service struct {
+	repo iVoucherRepo
+}
+
+func NewService(r iVoucherRepo) *service {
+	return &service{
+		repo: r,
+	}
+}
+
+func (s *service) CreateBatch(ctx context.Context, b *Batch) (*Batch, error) {
+	b.Name = strings.TrimSpace(b.Name)
+	if b.UsageLimit == 0 {
+		b.UsageLimit = 1
+	}
+
+	switch {
+	case b.Name == "":
+		return nil, &ValidationError{Field: "name", Reason: "empty"}
+	case len(b.Name) > maxNameLength:
+		return nil, &ValidationError{Field: "name", Reason: "too long"}
+	case b.Count <= 0 || b.Count > MaxBatchSize:
+		return nil, &ValidationError{Field: "count", Reason: fmt.Sprintf("must be from 1 to %d", MaxBatchSize)}
+	case b.Value <= 0:
+		return nil, &ValidationError{Field: "value", Reason: "must be positive"}
+	case b.UsageLimit < 0:
+		return nil, &ValidationError{Field: "usage_limit", Reason: "must be positive"}
+	case !b.ExpiresAt.After(time.Now()):
+		return nil, &ValidationError{Field: "expires_at", Reason: "must be in the future"}
+	}
+
+	created, err := s.repo.CreateBatch(ctx, b)
+	if err != nil {
+		logger.Log(ctx).Errorf("voucher: can't create batch, %v", err)
+		return nil, err
+	}
+	logger.Log(ctx).Infof("voucher: generated %d codes worth %s in batch `%s` (%d)",
+		created.Count, created.Value, created.Name, created.ID)
+	return created, nil
+}
+
+func (s *service) Batches(ctx context.Context) ([]*Batch, error) {
+	batches, err := s.repo.GetBatches(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("voucher: can't get batches, %v", err)
+		return nil, err
+	}
+	return batches, nil
+}
+
+// Credits the voucher value to the authorized user.
+func (s *service) Redeem(ctx context.Context, code string) (*Redemption, error) {
+	userID, err := session.GetAuthUserID(ctx)
+	if err != nil {
+		logger.Log(ctx).Errorf("voucher: can't get authorized user, %v", err)
+		return nil, err
+	}
+
+	// Typos are caught by the checksum without touching the DB
+	code = normalizeCode(code)
+	if !validCode(code) {
+		return nil, fmt.Errorf("voucher: code `%s`, %w", code, errBadCode)
+	}
+
+	red, err := s.repo.Redeem(ctx, userID, code)
+	if err != nil {
+		logger.Log(ctx).Errorf("voucher: redemption failed, %v", err)
+		return nil, err
+	}
+	logger.Log(ctx).Infof("voucher: user `%s` redeemed %s with `%s`", userID, red.Sum, red.Code)
+	return red, nil
+}